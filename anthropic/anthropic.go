@@ -0,0 +1,52 @@
+// Package anthropic holds helpers for writing the Anthropic Messages API wire
+// format back to clients.
+package anthropic
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// ErrorType maps an HTTP status to the matching Anthropic error type.
+func ErrorType(status int) string {
+	switch {
+	case status == http.StatusBadRequest:
+		return "invalid_request_error"
+	case status == http.StatusUnauthorized:
+		return "authentication_error"
+	case status == http.StatusForbidden:
+		return "permission_error"
+	case status == http.StatusNotFound:
+		return "not_found_error"
+	case status == http.StatusRequestEntityTooLarge:
+		return "request_too_large"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status == 529 || status == http.StatusServiceUnavailable:
+		return "overloaded_error"
+	case status >= 500:
+		return "api_error"
+	}
+	return "invalid_request_error"
+}
+
+// ErrorBody returns an error response body in the Anthropic error schema.
+func ErrorBody(errorType, message string) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":  "error",
+		"error": map[string]interface{}{"type": errorType, "message": message},
+	})
+	return data
+}
+
+// ErrorEvent returns an SSE error event for failures after a stream started.
+func ErrorEvent(errorType, message string) []byte {
+	return Event("error", json.RawMessage(ErrorBody(errorType, message)))
+}
+
+// Event formats a single SSE event.
+func Event(name string, payload interface{}) []byte {
+	data, _ := json.Marshal(payload)
+	return []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", name, data))
+}
@@ -0,0 +1,95 @@
+package bedrock
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/schachte/claudecode-opencode-proxy/anthropic"
+)
+
+// AnthropicVersion is the body-level API version Bedrock expects.
+const AnthropicVersion = "bedrock-2023-05-31"
+
+// InvokePath returns the Bedrock runtime path for a model. Model IDs and ARNs
+// contain ':' and '/', so the ID is escaped as a single path segment.
+func InvokePath(model string, streaming bool) string {
+	if streaming {
+		return "/model/" + Escape(model) + "/invoke-with-response-stream"
+	}
+	return "/model/" + Escape(model) + "/invoke"
+}
+
+// TranslateRequest turns an Anthropic Messages body into a Bedrock invoke
+// body: the model moves into the URL, streaming is chosen by the path, and
+// beta flags move from the anthropic-beta header into the body.
+func TranslateRequest(req map[string]interface{}, betaHeader string) ([]byte, error) {
+	out := make(map[string]interface{}, len(req)+1)
+	for key, value := range req {
+		switch key {
+		case "model", "stream":
+			continue
+		}
+		out[key] = value
+	}
+	out["anthropic_version"] = AnthropicVersion
+
+	var betas []string
+	for _, beta := range strings.Split(betaHeader, ",") {
+		if beta = strings.TrimSpace(beta); beta != "" {
+			betas = append(betas, beta)
+		}
+	}
+	if len(betas) > 0 {
+		out["anthropic_beta"] = betas
+	}
+	return json.Marshal(out)
+}
+
+// TranslateError converts a Bedrock error body ({"message": ...}) into the
+// Anthropic error schema.
+func TranslateError(status int, errorKind string, data []byte) []byte {
+	errorType := anthropic.ErrorType(status)
+	if errorKind != "" {
+		// x-amzn-ErrorType looks like "ThrottlingException:http://...".
+		kind, _, _ := strings.Cut(errorKind, ":")
+		if mapped := exceptionErrorType(lowerFirst(kind)); mapped != "api_error" {
+			errorType = mapped
+		}
+	}
+	return anthropic.ErrorBody(errorType, errorMessage(data, errorKind))
+}
+
+func errorMessage(data []byte, fallback string) string {
+	var body struct {
+		Message      string `json:"message"`
+		MessageUpper string `json:"Message"`
+	}
+	if json.Unmarshal(data, &body) == nil {
+		if body.Message != "" {
+			return body.Message
+		}
+		if body.MessageUpper != "" {
+			return body.MessageUpper
+		}
+	}
+	if text := strings.TrimSpace(string(data)); text != "" {
+		return text
+	}
+	if fallback != "" {
+		return fallback
+	}
+	return http.StatusText(http.StatusBadGateway)
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+// Endpoint returns the Bedrock runtime base URL for a region.
+func Endpoint(region string) string {
+	return "https://bedrock-runtime." + region + ".amazonaws.com"
+}
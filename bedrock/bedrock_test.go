@@ -0,0 +1,64 @@
+package bedrock
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestTranslateRequest(t *testing.T) {
+	req := map[string]interface{}{
+		"model":      "anthropic.claude-sonnet-4",
+		"stream":     true,
+		"max_tokens": float64(100),
+		"messages":   []interface{}{map[string]interface{}{"role": "user", "content": "hi"}},
+	}
+	data, err := TranslateRequest(req, "context-1m-2025-08-07, interleaved-thinking-2025-05-14,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"anthropic_version": AnthropicVersion,
+		"anthropic_beta":    []interface{}{"context-1m-2025-08-07", "interleaved-thinking-2025-05-14"},
+		"max_tokens":        float64(100),
+		"messages":          []interface{}{map[string]interface{}{"role": "user", "content": "hi"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TranslateRequest =\n%v\nwant\n%v", got, want)
+	}
+	if _, ok := req["model"]; !ok {
+		t.Error("TranslateRequest modified its input")
+	}
+}
+
+func TestTranslateRequestWithoutBeta(t *testing.T) {
+	data, err := TranslateRequest(map[string]interface{}{"max_tokens": 1}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	json.Unmarshal(data, &got)
+	if _, ok := got["anthropic_beta"]; ok {
+		t.Errorf("anthropic_beta set without a header: %s", data)
+	}
+}
+
+func TestTranslateError(t *testing.T) {
+	data := TranslateError(400, "ThrottlingException:http://internal.amazon.com/coral/com.amazon.bedrock/", []byte(`{"message":"Too many tokens"}`))
+	var got struct {
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Error.Type != "rate_limit_error" || got.Error.Message != "Too many tokens" {
+		t.Errorf("TranslateError = %s", data)
+	}
+}
@@ -0,0 +1,93 @@
+// Package bedrock adapts Anthropic Messages requests to Amazon Bedrock: AWS
+// credential lookup, SigV4 request signing and event-stream decoding.
+package bedrock
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// LoadCredentials resolves AWS credentials in order: the static credentials
+// passed in, the AWS_* environment variables, then the shared credentials file
+// (AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials). profile falls back to
+// AWS_PROFILE and then "default".
+func LoadCredentials(static Credentials, profile string) (Credentials, error) {
+	if static.AccessKeyID != "" && static.SecretAccessKey != "" {
+		return static, nil
+	}
+
+	env := Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if env.AccessKeyID != "" && env.SecretAccessKey != "" {
+		return env, nil
+	}
+
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+	file := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if file == "" {
+		file = filepath.Join(os.Getenv("HOME"), ".aws", "credentials")
+	}
+	return loadSharedCredentials(file, profile)
+}
+
+func loadSharedCredentials(file, profile string) (Credentials, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("no AWS credentials in config or environment, and %w", err)
+	}
+	defer f.Close()
+
+	var creds Credentials
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = value
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = value
+		case "aws_session_token":
+			creds.SessionToken = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Credentials{}, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return Credentials{}, fmt.Errorf("no credentials for profile %q in %s", profile, file)
+	}
+	return creds, nil
+}
@@ -0,0 +1,220 @@
+package bedrock
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/schachte/claudecode-opencode-proxy/anthropic"
+)
+
+// Message is one frame of the AWS event-stream encoding. Only string header
+// values are kept; other header types are skipped.
+type Message struct {
+	Headers map[string]string
+	Payload []byte
+}
+
+const (
+	preludeLen  = 12
+	maxFrameLen = 16 << 20
+)
+
+// ReadMessage reads one event-stream frame, verifying both checksums.
+func ReadMessage(r io.Reader) (Message, error) {
+	prelude := make([]byte, preludeLen)
+	if _, err := io.ReadFull(r, prelude); err != nil {
+		return Message{}, err
+	}
+	totalLen := binary.BigEndian.Uint32(prelude[0:4])
+	headersLen := binary.BigEndian.Uint32(prelude[4:8])
+	if crc32.ChecksumIEEE(prelude[0:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return Message{}, errors.New("event-stream prelude checksum mismatch")
+	}
+	if totalLen < preludeLen+4+headersLen || totalLen > maxFrameLen {
+		return Message{}, fmt.Errorf("invalid event-stream frame length %d", totalLen)
+	}
+
+	frame := make([]byte, totalLen)
+	copy(frame, prelude)
+	if _, err := io.ReadFull(r, frame[preludeLen:]); err != nil {
+		return Message{}, io.ErrUnexpectedEOF
+	}
+	crcOffset := totalLen - 4
+	if crc32.ChecksumIEEE(frame[:crcOffset]) != binary.BigEndian.Uint32(frame[crcOffset:]) {
+		return Message{}, errors.New("event-stream message checksum mismatch")
+	}
+
+	headers, err := parseHeaders(frame[preludeLen : preludeLen+headersLen])
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{Headers: headers, Payload: frame[preludeLen+headersLen : crcOffset]}, nil
+}
+
+func parseHeaders(data []byte) (map[string]string, error) {
+	headers := make(map[string]string)
+	for len(data) > 0 {
+		nameLen := int(data[0])
+		if len(data) < 1+nameLen+1 {
+			return nil, errors.New("truncated event-stream header")
+		}
+		name := string(data[1 : 1+nameLen])
+		valueType := data[1+nameLen]
+		data = data[2+nameLen:]
+
+		var size int
+		switch valueType {
+		case 0, 1: // bool true, bool false
+			size = 0
+		case 2: // byte
+			size = 1
+		case 3: // short
+			size = 2
+		case 4: // int
+			size = 4
+		case 5, 8: // long, timestamp
+			size = 8
+		case 9: // uuid
+			size = 16
+		case 6, 7: // bytes, string
+			if len(data) < 2 {
+				return nil, errors.New("truncated event-stream header")
+			}
+			size = int(binary.BigEndian.Uint16(data[:2]))
+			data = data[2:]
+			if len(data) < size {
+				return nil, errors.New("truncated event-stream header")
+			}
+			if valueType == 7 {
+				headers[name] = string(data[:size])
+			}
+		default:
+			return nil, fmt.Errorf("unknown event-stream header type %d", valueType)
+		}
+		if len(data) < size {
+			return nil, errors.New("truncated event-stream header")
+		}
+		data = data[size:]
+	}
+	return headers, nil
+}
+
+// EncodeMessage builds an event-stream frame with string headers. It is the
+// inverse of ReadMessage and is used by local stand-in servers.
+func EncodeMessage(headers map[string]string, payload []byte) []byte {
+	var hdr bytes.Buffer
+	for name, value := range headers {
+		hdr.WriteByte(byte(len(name)))
+		hdr.WriteString(name)
+		hdr.WriteByte(7)
+		binary.Write(&hdr, binary.BigEndian, uint16(len(value)))
+		hdr.WriteString(value)
+	}
+
+	totalLen := preludeLen + hdr.Len() + len(payload) + 4
+	frame := make([]byte, 0, totalLen)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(totalLen))
+	frame = binary.BigEndian.AppendUint32(frame, uint32(hdr.Len()))
+	frame = binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE(frame))
+	frame = append(frame, hdr.Bytes()...)
+	frame = append(frame, payload...)
+	return binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE(frame))
+}
+
+// EncodeChunk wraps an Anthropic stream event as a Bedrock "chunk" frame.
+func EncodeChunk(event []byte) []byte {
+	payload, _ := json.Marshal(map[string]string{"bytes": base64.StdEncoding.EncodeToString(event)})
+	return EncodeMessage(map[string]string{
+		":message-type": "event",
+		":event-type":   "chunk",
+		":content-type": "application/json",
+	}, payload)
+}
+
+// SSEReader decodes a Bedrock invoke-with-response-stream body into the
+// Anthropic SSE stream the client expects.
+type SSEReader struct {
+	src  io.ReadCloser
+	buf  []byte
+	done bool
+}
+
+func NewSSEReader(src io.ReadCloser) *SSEReader {
+	return &SSEReader{src: src}
+}
+
+func (s *SSEReader) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		msg, err := ReadMessage(s.src)
+		if err == io.EOF {
+			s.done = true
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		s.buf = append(s.buf, messageToSSE(msg)...)
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func (s *SSEReader) Close() error {
+	return s.src.Close()
+}
+
+func messageToSSE(msg Message) []byte {
+	switch msg.Headers[":message-type"] {
+	case "exception", "error":
+		kind := msg.Headers[":exception-type"]
+		if kind == "" {
+			kind = msg.Headers[":error-code"]
+		}
+		return anthropic.ErrorEvent(exceptionErrorType(kind), errorMessage(msg.Payload, kind))
+	}
+	if msg.Headers[":event-type"] != "chunk" {
+		return nil
+	}
+
+	var chunk struct {
+		Bytes string `json:"bytes"`
+	}
+	if err := json.Unmarshal(msg.Payload, &chunk); err != nil {
+		return nil
+	}
+	event, err := base64.StdEncoding.DecodeString(chunk.Bytes)
+	if err != nil {
+		return nil
+	}
+	var head struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(event, &head); err != nil || head.Type == "" {
+		return nil
+	}
+	return anthropic.Event(head.Type, json.RawMessage(event))
+}
+
+func exceptionErrorType(kind string) string {
+	switch kind {
+	case "throttlingException":
+		return "rate_limit_error"
+	case "serviceUnavailableException", "modelNotReadyException":
+		return "overloaded_error"
+	case "validationException":
+		return "invalid_request_error"
+	case "accessDeniedException":
+		return "permission_error"
+	}
+	return "api_error"
+}
@@ -0,0 +1,86 @@
+package bedrock
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/schachte/claudecode-opencode-proxy/anthropic"
+)
+
+func TestEncodeChunkReadMessageRoundTrip(t *testing.T) {
+	event := []byte(`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"hi"}}`)
+	msg, err := ReadMessage(bytes.NewReader(EncodeChunk(event)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Headers[":event-type"] != "chunk" || msg.Headers[":message-type"] != "event" {
+		t.Errorf("headers = %v", msg.Headers)
+	}
+	var payload struct {
+		Bytes []byte `json:"bytes"` // base64 in JSON
+	}
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(payload.Bytes, event) {
+		t.Errorf("payload = %s, want %s", payload.Bytes, event)
+	}
+}
+
+func TestReadMessageChecksums(t *testing.T) {
+	frame := EncodeChunk([]byte(`{"type":"ping"}`))
+
+	corrupt := append([]byte(nil), frame...)
+	corrupt[len(corrupt)-10] ^= 0xff
+	if _, err := ReadMessage(bytes.NewReader(corrupt)); err == nil {
+		t.Error("expected a message checksum error")
+	}
+
+	corrupt = append([]byte(nil), frame...)
+	corrupt[2] ^= 0xff
+	if _, err := ReadMessage(bytes.NewReader(corrupt)); err == nil {
+		t.Error("expected a prelude checksum error")
+	}
+
+	if _, err := ReadMessage(bytes.NewReader(frame[:len(frame)-3])); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("short frame: err = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestSSEReader(t *testing.T) {
+	events := []string{
+		`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","content":[]}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}`,
+		`{"type":"message_stop"}`,
+	}
+	var body bytes.Buffer
+	for _, event := range events {
+		body.Write(EncodeChunk([]byte(event)))
+	}
+	// Frames that are not chunks are skipped.
+	body.Write(EncodeMessage(map[string]string{":message-type": "event", ":event-type": "metadata"}, []byte(`{}`)))
+	body.Write(EncodeMessage(map[string]string{
+		":message-type":   "exception",
+		":exception-type": "throttlingException",
+	}, []byte(`{"message":"Too many requests"}`)))
+
+	got, err := io.ReadAll(NewSSEReader(io.NopCloser(&body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want bytes.Buffer
+	for _, event := range events {
+		var head struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal([]byte(event), &head)
+		want.Write(anthropic.Event(head.Type, json.RawMessage(event)))
+	}
+	want.Write(anthropic.ErrorEvent("rate_limit_error", "Too many requests"))
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("SSE mismatch\ngot:\n%s\nwant:\n%s", got, want.Bytes())
+	}
+}
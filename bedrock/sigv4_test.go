@@ -0,0 +1,122 @@
+package bedrock
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Reference vectors from the AWS Signature Version 4 test suite and the
+// IAM ListUsers example in the SigV4 documentation.
+var (
+	exampleCreds = Credentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	exampleTime = time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+)
+
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func TestSignReferenceVectors(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		url       string
+		header    map[string]string
+		service   string
+		signature string
+	}{
+		{
+			name:      "get-vanilla",
+			method:    "GET",
+			url:       "https://example.amazonaws.com/",
+			service:   "service",
+			signature: "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:      "post-vanilla",
+			method:    "POST",
+			url:       "https://example.amazonaws.com/",
+			service:   "service",
+			signature: "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:      "get-vanilla-query-order-key-case",
+			method:    "GET",
+			url:       "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			service:   "service",
+			signature: "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name:      "iam-list-users",
+			method:    "GET",
+			url:       "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08",
+			header:    map[string]string{"Content-Type": "application/x-www-form-urlencoded; charset=utf-8"},
+			service:   "iam",
+			signature: "5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+			sign(req, emptyPayloadHash, exampleCreds, "us-east-1", tt.service, exampleTime)
+
+			auth := req.Header.Get("Authorization")
+			if !strings.HasSuffix(auth, "Signature="+tt.signature) {
+				t.Errorf("Authorization = %s\nwant signature %s", auth, tt.signature)
+			}
+			scope := "Credential=AKIDEXAMPLE/20150830/us-east-1/" + tt.service + "/aws4_request"
+			if !strings.Contains(auth, scope) {
+				t.Errorf("Authorization = %s, want scope %s", auth, scope)
+			}
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("X-Amz-Date = %s", got)
+			}
+		})
+	}
+}
+
+func TestSignBedrockHeaders(t *testing.T) {
+	body := []byte(`{"max_tokens":1}`)
+	req, err := http.NewRequest("POST", "https://bedrock-runtime.us-east-1.amazonaws.com"+InvokePath("anthropic.claude-3-haiku-20240307-v1:0", false), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("anthropic-version", "2023-06-01")
+	creds := exampleCreds
+	creds.SessionToken = "session"
+	Sign(req, body, creds, "us-east-1", exampleTime)
+
+	if got, want := req.Header.Get("X-Amz-Content-Sha256"), hashHex(body); got != want {
+		t.Errorf("X-Amz-Content-Sha256 = %s, want %s", got, want)
+	}
+	if got := req.Header.Get("X-Amz-Security-Token"); got != "session" {
+		t.Errorf("X-Amz-Security-Token = %q", got)
+	}
+	auth := req.Header.Get("Authorization")
+	want := "SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date;x-amz-security-token,"
+	if !strings.Contains(auth, "/us-east-1/bedrock/aws4_request") || !strings.Contains(auth, want) {
+		t.Errorf("Authorization = %s", auth)
+	}
+}
+
+func TestCanonicalURIEscapesModelID(t *testing.T) {
+	path := InvokePath("arn:aws:bedrock:us-east-1:123:inference-profile/us.anthropic.claude", true)
+	req, err := http.NewRequest("POST", "https://bedrock-runtime.us-east-1.amazonaws.com"+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "/model/arn%253Aaws%253Abedrock%253Aus-east-1%253A123%253Ainference-profile%252Fus.anthropic.claude/invoke-with-response-stream"
+	if got := canonicalURI(req.URL.EscapedPath()); got != want {
+		t.Errorf("canonicalURI = %s\nwant %s", got, want)
+	}
+}
@@ -0,0 +1,228 @@
+// Package capture records proxied exchanges to disk for debugging, replay
+// and regression fixtures. Each exchange is one JSON file holding the client
+// request, the rewritten upstream request and the response the client got.
+// Credentials are redacted before anything is written. A nil *Exchange is
+// valid and records nothing, so callers need no enabled checks.
+package capture
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Exchange is one captured request.
+type Exchange struct {
+	ID       string    `json:"id"`
+	Time     time.Time `json:"time"`
+	Model    string    `json:"model,omitempty"`
+	Request  Message   `json:"request"`
+	Upstream *Upstream `json:"upstream,omitempty"`
+	Response Message   `json:"response"`
+}
+
+// Message is one side of an HTTP exchange. URL is the path and query for
+// client requests and the full URL for upstream requests.
+type Message struct {
+	Method string      `json:"method,omitempty"`
+	URL    string      `json:"url,omitempty"`
+	Status int         `json:"status,omitempty"`
+	Header http.Header `json:"headers,omitempty"`
+	Body   Body        `json:"body,omitempty"`
+}
+
+// Upstream is the request as sent to the upstream that answered, after rule
+// rewriting, model mapping and protocol translation, and the status and
+// headers it replied with.
+type Upstream struct {
+	Name     string  `json:"name"`
+	Request  Message `json:"request"`
+	Response Message `json:"response"`
+}
+
+// Body is stored inline as JSON when it is a JSON object or array, and as a
+// string otherwise (such as an SSE stream).
+type Body []byte
+
+func (b Body) MarshalJSON() ([]byte, error) {
+	trimmed := bytes.TrimSpace(b)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed) {
+		return trimmed, nil
+	}
+	return json.Marshal(string(b))
+}
+
+func (b *Body) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*b = Body(s)
+		return nil
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+// New starts capturing a client request.
+func New(id string, start time.Time, r *http.Request, body []byte) *Exchange {
+	return &Exchange{
+		ID:   id,
+		Time: start,
+		Request: Message{
+			Method: r.Method,
+			URL:    r.URL.RequestURI(),
+			Header: redactHeader(r.Header),
+			Body:   redactBody(body),
+		},
+	}
+}
+
+// SetModel records the requested model.
+func (e *Exchange) SetModel(model string) {
+	if e != nil {
+		e.Model = model
+	}
+}
+
+// SetUpstream records the request sent upstream. Later attempts replace
+// earlier ones, so the capture shows the attempt that produced the response.
+func (e *Exchange) SetUpstream(name, method, url string, header http.Header, body []byte) {
+	if e == nil {
+		return
+	}
+	e.Upstream = &Upstream{
+		Name:    name,
+		Request: Message{Method: method, URL: url, Header: redactHeader(header), Body: redactBody(body)},
+	}
+}
+
+// SetUpstreamResponse records the upstream's status and headers.
+func (e *Exchange) SetUpstreamResponse(status int, header http.Header) {
+	if e == nil || e.Upstream == nil {
+		return
+	}
+	e.Upstream.Response = Message{Status: status, Header: redactHeader(header)}
+}
+
+// Writer wraps the client's ResponseWriter so the response is captured as
+// the client receives it, including proxy-generated errors.
+func (e *Exchange) Writer(w http.ResponseWriter) http.ResponseWriter {
+	if e == nil {
+		return w
+	}
+	return &responseWriter{ResponseWriter: w, e: e}
+}
+
+// Save writes the exchange to dir as <id>.json, readable only by the owner
+// since it holds prompts. id is the request-id the client received.
+func (e *Exchange) Save(dir, id string) error {
+	if e == nil {
+		return nil
+	}
+	e.ID = id
+	e.Response.Header = redactHeader(e.Response.Header)
+	e.Response.Body = redactBody(e.Response.Body)
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(Path(dir, e.ID), append(data, '\n'), 0600)
+}
+
+// Path returns where the exchange with id is stored in dir.
+func Path(dir, id string) string {
+	return filepath.Join(dir, unsafeChars.ReplaceAllString(id, "_")+".json")
+}
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// Load reads a captured exchange from a file.
+func Load(path string) (*Exchange, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var e Exchange
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	e           *Exchange
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.e.Response.Status = status
+		w.e.Response.Header = w.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.e.Response.Body = append(w.e.Response.Body, p...)
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Redacted replaces secret values.
+const Redacted = "[REDACTED]"
+
+// secretHeaders name headers that carry credentials. Headers whose names
+// contain "token", "secret" or "key" are redacted as well.
+var secretHeaders = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"set-cookie":          true,
+}
+
+func redactHeader(h http.Header) http.Header {
+	if h == nil {
+		return nil
+	}
+	out := h.Clone()
+	for name := range out {
+		lower := strings.ToLower(name)
+		if secretHeaders[lower] || strings.Contains(lower, "token") ||
+			strings.Contains(lower, "secret") || strings.Contains(lower, "key") {
+			out[name] = []string{Redacted}
+		}
+	}
+	return out
+}
+
+// secretValues match credentials that can turn up inside bodies: API keys,
+// bearer tokens and AWS access key IDs.
+var secretValues = regexp.MustCompile(`sk-[A-Za-z0-9_-]{16,}|(?i:bearer\s+)[A-Za-z0-9._~+/=-]{16,}|\bA[KS]IA[0-9A-Z]{16}\b`)
+
+func redactBody(body []byte) Body {
+	if body == nil {
+		return nil
+	}
+	return Body(secretValues.ReplaceAll(body, []byte(Redacted)))
+}
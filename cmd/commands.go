@@ -19,6 +19,7 @@ import (
 	"github.com/schachte/claudecode-opencode-proxy/claude"
 	"github.com/schachte/claudecode-opencode-proxy/config"
 	"github.com/schachte/claudecode-opencode-proxy/proxy"
+	"github.com/schachte/claudecode-opencode-proxy/usage"
 )
 
 var lastAuthChoiceFile = filepath.Join(config.ConfigDir, "last-auth-choice")
@@ -32,6 +33,11 @@ func Config(args []string) {
 		return
 	}
 
+	if args[0] == "rules" {
+		ConfigRules(args[1:])
+		return
+	}
+
 	for i := 0; i < len(args); i++ {
 		switch args[i] {
 		case "--target":
@@ -68,6 +74,38 @@ func Config(args []string) {
 				cfg.CfClientSecret = args[i+1]
 				i++
 			}
+		case "--protocol":
+			if i+1 < len(args) {
+				cfg.Protocol = args[i+1]
+				i++
+			}
+		case "--aws-region", "--aws-profile":
+			if i+1 < len(args) {
+				if cfg.AWS == nil {
+					cfg.AWS = &config.AWSConfig{}
+				}
+				if args[i] == "--aws-region" {
+					cfg.AWS.Region = args[i+1]
+				} else {
+					cfg.AWS.Profile = args[i+1]
+				}
+				i++
+			}
+		case "--vertex-project", "--vertex-region", "--vertex-credentials":
+			if i+1 < len(args) {
+				if cfg.Vertex == nil {
+					cfg.Vertex = &config.VertexConfig{}
+				}
+				switch args[i] {
+				case "--vertex-project":
+					cfg.Vertex.ProjectID = args[i+1]
+				case "--vertex-region":
+					cfg.Vertex.Region = args[i+1]
+				case "--vertex-credentials":
+					cfg.Vertex.Credentials = args[i+1]
+				}
+				i++
+			}
 		case "--proxy":
 			if i+1 < len(args) {
 				cfg.Proxy = args[i+1]
@@ -82,6 +120,198 @@ func Config(args []string) {
 			cfg.InsecureSkip = true
 		case "--no-insecure-skip-verify":
 			cfg.InsecureSkip = false
+		case "--add-upstream":
+			if i+1 < len(args) {
+				name, target, ok := strings.Cut(args[i+1], "=")
+				if !ok {
+					log.Fatalf("Invalid upstream %q, expected name=url", args[i+1])
+				}
+				// The current target becomes the primary; the new entry
+				// inherits the top-level auth settings until edited.
+				if len(cfg.Upstreams) == 0 {
+					cfg.Upstreams = cfg.ResolveUpstreams()
+				}
+				up := cfg.ResolveUpstreams()[0]
+				up.Name, up.Target = name, target
+				up.AuthType, up.APIKey, up.LoginURL = cfg.AuthType, cfg.APIKey, cfg.LoginURL
+				up.CfAccess, up.CfClientID, up.CfClientSecret = cfg.CfAccess, cfg.CfClientID, cfg.CfClientSecret
+				cfg.Upstreams = append(cfg.Upstreams, up)
+				i++
+			}
+		case "--remove-upstream":
+			if i+1 < len(args) {
+				var kept []config.Upstream
+				for _, up := range cfg.Upstreams {
+					if up.Name != args[i+1] {
+						kept = append(kept, up)
+					}
+				}
+				cfg.Upstreams = kept
+				i++
+			}
+		case "--price":
+			if i+1 < len(args) {
+				model, price, err := parsePrice(args[i+1])
+				if err != nil {
+					log.Fatalf("Invalid price %q: %v", args[i+1], err)
+				}
+				if cfg.Prices == nil {
+					cfg.Prices = make(map[string]usage.Price)
+				}
+				cfg.Prices[model] = price
+				i++
+			}
+		case "--limits":
+			if i+1 < len(args) {
+				name, spec := "", args[i+1]
+				if before, after, ok := strings.Cut(spec, ":"); ok && !strings.Contains(before, "=") {
+					name, spec = before, after
+				}
+				limits, err := parseLimits(spec)
+				if err != nil {
+					log.Fatalf("Invalid limits %q: %v", args[i+1], err)
+				}
+				if name == "" {
+					cfg.Limits = limits
+				} else {
+					found := false
+					for j := range cfg.Upstreams {
+						if cfg.Upstreams[j].Name == name {
+							cfg.Upstreams[j].Limits = limits
+							found = true
+						}
+					}
+					if !found {
+						log.Fatalf("Unknown upstream %q", name)
+					}
+				}
+				i++
+			}
+		case "--retry":
+			if i+1 < len(args) {
+				var nums []int
+				for _, part := range strings.Split(args[i+1], ",") {
+					n, err := strconv.Atoi(strings.TrimSpace(part))
+					if err != nil || n < 0 {
+						log.Fatalf("Invalid retry policy %q: expected attempts[,initial_ms,max_ms]", args[i+1])
+					}
+					nums = append(nums, n)
+				}
+				retry := &config.RetryConfig{MaxAttempts: &nums[0]}
+				if len(nums) > 1 {
+					retry.InitialBackoffMs = nums[1]
+				}
+				if len(nums) > 2 {
+					retry.MaxBackoffMs = nums[2]
+				}
+				cfg.Retry = retry
+				i++
+			}
+		case "--circuit-breaker":
+			if i+1 < len(args) {
+				threshold, cooldown, _ := strings.Cut(args[i+1], ",")
+				breaker := &config.BreakerConfig{}
+				var err error
+				if breaker.FailureThreshold, err = strconv.Atoi(threshold); err != nil {
+					log.Fatalf("Invalid circuit breaker %q: expected failures[,cooldown_seconds]", args[i+1])
+				}
+				if cooldown != "" {
+					if breaker.CooldownSeconds, err = strconv.Atoi(cooldown); err != nil {
+						log.Fatalf("Invalid circuit breaker %q: expected failures[,cooldown_seconds]", args[i+1])
+					}
+				}
+				cfg.Breaker = breaker
+				i++
+			}
+		case "--timeouts":
+			if i+1 < len(args) {
+				timeouts, err := parseTimeouts(args[i+1])
+				if err != nil {
+					log.Fatalf("Invalid timeouts %q: %v", args[i+1], err)
+				}
+				cfg.Timeouts = timeouts
+				i++
+			}
+		case "--log-rotate":
+			if i+1 < len(args) {
+				rotate, err := parseLogRotate(args[i+1])
+				if err != nil {
+					log.Fatalf("Invalid log rotation %q: %v", args[i+1], err)
+				}
+				cfg.Log = rotate
+				i++
+			}
+		case "--otlp-endpoint":
+			if i+1 < len(args) {
+				if args[i+1] == "" || args[i+1] == "none" {
+					cfg.Tracing = nil
+				} else {
+					if cfg.Tracing == nil {
+						cfg.Tracing = &config.TracingConfig{}
+					}
+					cfg.Tracing.Endpoint = args[i+1]
+				}
+				i++
+			}
+		case "--budget":
+			if i+1 < len(args) {
+				budget, err := parseBudget(args[i+1])
+				if err != nil {
+					log.Fatalf("Invalid budget %q: %v", args[i+1], err)
+				}
+				cfg.Budgets = append(removeBudget(cfg.Budgets, budget.Period, budget.Model), budget)
+				i++
+			}
+		case "--remove-budget":
+			if i+1 < len(args) {
+				period, model, _ := strings.Cut(args[i+1], ":")
+				cfg.Budgets = removeBudget(cfg.Budgets, period, model)
+				i++
+			}
+		case "--forward-header":
+			if i+1 < len(args) {
+				cfg.ForwardHeaders = append(cfg.ForwardHeaders, args[i+1])
+				i++
+			}
+		case "--drop-header":
+			if i+1 < len(args) {
+				cfg.DropHeaders = append(cfg.DropHeaders, args[i+1])
+				i++
+			}
+		case "--model-map":
+			if i+1 < len(args) {
+				from, to, ok := strings.Cut(args[i+1], "=")
+				if !ok {
+					log.Fatalf("Invalid model mapping %q, expected from=to", args[i+1])
+				}
+				if cfg.Models == nil {
+					cfg.Models = make(map[string]string)
+				}
+				if to == "" {
+					delete(cfg.Models, from)
+				} else {
+					cfg.Models[from] = to
+				}
+				i++
+			}
+		case "--route":
+			if i+1 < len(args) {
+				model, upstream, ok := strings.Cut(args[i+1], "=")
+				if !ok {
+					log.Fatalf("Invalid route %q, expected model=upstream", args[i+1])
+				}
+				var routes []config.Route
+				for _, route := range cfg.Routes {
+					if route.Model != model {
+						routes = append(routes, route)
+					}
+				}
+				if upstream != "" {
+					routes = append(routes, config.Route{Model: model, Upstream: upstream})
+				}
+				cfg.Routes = routes
+				i++
+			}
 		case "--reset":
 			cfg = config.DefaultConfig()
 		}
@@ -167,7 +397,10 @@ func Disable() {
 	fmt.Println("  claude /login")
 }
 
-func ProxyBackground(port int, bindAddr string, verbose bool, quiet bool) {
+func ProxyBackground(port int, bindAddr string, logs proxy.LogOptions, captureDir string) {
+	if err := logs.Validate(); err != nil {
+		log.Fatalf("Invalid log options: %v", err)
+	}
 	cfg := config.LoadConfig()
 
 	// Warn if using default placeholder URL
@@ -189,23 +422,34 @@ func ProxyBackground(port int, bindAddr string, verbose bool, quiet bool) {
 
 	os.MkdirAll(config.ConfigDir, 0755)
 
-	args := []string{"serve", "-p", strconv.Itoa(port), "-b", bindAddr, "-f"}
-	if verbose {
+	args := []string{"serve", "-p", strconv.Itoa(port), "-b", bindAddr, "-f", "--log-file", config.LogFile}
+	if logs.Verbose {
 		args = append(args, "-v")
 	}
-	if quiet {
+	if logs.Quiet {
 		args = append(args, "-q")
 	}
+	if logs.Format != "" {
+		args = append(args, "--log-format", logs.Format)
+	}
+	if logs.Level != "" {
+		args = append(args, "--log-level", logs.Level)
+	}
+	if captureDir != "" {
+		args = append(args, "--capture", captureDir)
+	}
 
-	logF, err := os.OpenFile(config.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	// The proxy writes and rotates its own log file. Anything it prints
+	// outside that log, such as a panic or a failure before the log is
+	// open, goes to the crash file.
+	crash, err := os.OpenFile(config.CrashFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
-		log.Fatalf("Failed to open log file: %v", err)
+		log.Fatalf("Failed to open %s: %v", config.CrashFile, err)
 	}
-
+	defer crash.Close()
 	cmd := exec.Command(os.Args[0], args...)
-	cmd.Stdout = logF
-	cmd.Stderr = logF
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Stdout, cmd.Stderr = crash, crash
 
 	if err := cmd.Start(); err != nil {
 		log.Fatalf("Failed to start proxy: %v", err)
@@ -217,7 +461,7 @@ func ProxyBackground(port int, bindAddr string, verbose bool, quiet bool) {
 	fmt.Printf("Logs: %s\n", config.LogFile)
 	fmt.Println()
 	fmt.Println("Commands:")
-	fmt.Printf("  tail -f %s\n", config.LogFile)
+	fmt.Println("  claude-opencode-proxy logs")
 	fmt.Println("  claude-opencode-proxy stop")
 }
 
@@ -251,15 +495,11 @@ func ProxyStop() {
 	fmt.Printf("Proxy stopped (PID: %d)\n", pid)
 }
 
-func ProxyLogs() {
-	cmd := exec.Command("tail", "-f", config.LogFile)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Run()
-}
-
-func ProxyForeground(port int, bindAddr string, verbose bool, quiet bool) {
-	proxy.Run(port, bindAddr, verbose, quiet)
+func ProxyForeground(port int, bindAddr string, logs proxy.LogOptions, captureDir string) {
+	if err := logs.Validate(); err != nil {
+		log.Fatalf("Invalid log options: %v", err)
+	}
+	proxy.Run(port, bindAddr, logs, captureDir)
 }
 
 func Login(args []string) {
@@ -302,6 +542,22 @@ func Status() {
 	fmt.Printf("API key: %s\n", cfg.APIKey)
 	fmt.Printf("Login URL: %s\n", cfg.LoginURL)
 	fmt.Printf("CF-Access: %v\n", cfg.CfAccess)
+	if cfg.Protocol != "" {
+		fmt.Printf("Protocol: %s\n", cfg.Protocol)
+	}
+	if cfg.AuthType == "bedrock" {
+		fmt.Printf("AWS region: %s\n", config.AWSRegion(cfg))
+		if cfg.AWS != nil && cfg.AWS.Profile != "" {
+			fmt.Printf("AWS profile: %s\n", cfg.AWS.Profile)
+		}
+	}
+	if cfg.AuthType == "vertex" && cfg.Vertex != nil {
+		fmt.Printf("Vertex project: %s\n", cfg.Vertex.ProjectID)
+		fmt.Printf("Vertex region: %s\n", cfg.Vertex.Region)
+		if cfg.Vertex.Credentials != "" {
+			fmt.Printf("Vertex credentials: %s\n", cfg.Vertex.Credentials)
+		}
+	}
 	if cfg.Proxy != "" {
 		fmt.Printf("Proxy: %s\n", cfg.Proxy)
 	}
@@ -311,13 +567,102 @@ func Status() {
 	if cfg.InsecureSkip {
 		fmt.Printf("Insecure Skip Verify: %v\n", cfg.InsecureSkip)
 	}
+	if cfg.Limits != nil {
+		fmt.Printf("Limits: %s\n", formatLimits(cfg.Limits))
+	}
+	policy := cfg.RetryPolicy()
+	if *policy.MaxAttempts > 1 {
+		fmt.Printf("Retry: %d attempts, backoff %dms-%dms\n", *policy.MaxAttempts, policy.InitialBackoffMs, policy.MaxBackoffMs)
+	} else {
+		fmt.Println("Retry: off")
+	}
+	t := cfg.TimeoutPolicy()
+	total := "none"
+	if *t.TotalSeconds > 0 {
+		total = fmt.Sprintf("%ds", *t.TotalSeconds)
+	}
+	fmt.Printf("Timeouts: connect=%ds tls=%ds header=%ds idle=%ds total=%s\n",
+		t.ConnectSeconds, t.TLSHandshakeSeconds, t.ResponseHeaderSeconds, t.IdleSeconds, total)
+	if endpoint := cfg.TracingEndpoint(); endpoint != "" {
+		fmt.Printf("Tracing: %s (service %s)\n", endpoint, cfg.TracingServiceName())
+	}
+	rotate := cfg.LogPolicy()
+	fmt.Printf("Log rotation: %dMB or %dh, keep %d, compress %v\n", rotate.MaxSizeMB, rotate.MaxAgeHours, rotate.MaxFiles, *rotate.Compress)
+	breaker := cfg.BreakerPolicy()
+	fmt.Printf("Circuit breaker: open after %d failures, cooldown %ds\n", breaker.FailureThreshold, breaker.CooldownSeconds)
+	fmt.Printf("Forward headers: %s\n", strings.Join(cfg.ForwardHeaders, ", "))
+	if len(cfg.DropHeaders) > 0 {
+		fmt.Printf("Drop headers: %s\n", strings.Join(cfg.DropHeaders, ", "))
+	}
+	if len(cfg.Upstreams) > 0 {
+		fmt.Println()
+		fmt.Println("=== Upstreams (failover order) ===")
+		for i, up := range cfg.ResolveUpstreams() {
+			fmt.Printf("%d. %s: %s (auth: %s, CF-Access: %v", i+1, up.Name, up.Target, up.AuthType, up.CfAccess)
+			if up.Protocol != "" {
+				fmt.Printf(", protocol: %s", up.Protocol)
+			}
+			if up.Limits != nil {
+				fmt.Printf(", limits: %s", formatLimits(up.Limits))
+			}
+			fmt.Println(")")
+		}
+	}
+	if len(cfg.Models) > 0 || len(cfg.Routes) > 0 {
+		fmt.Println()
+		fmt.Println("=== Model Routing ===")
+		aliases := make([]string, 0, len(cfg.Models))
+		for from := range cfg.Models {
+			aliases = append(aliases, from)
+		}
+		sort.Strings(aliases)
+		for _, from := range aliases {
+			fmt.Printf("Model: %s -> %s\n", from, cfg.Models[from])
+		}
+		for _, route := range cfg.Routes {
+			fmt.Printf("Route: %s -> %s\n", route.Model, route.Upstream)
+		}
+	}
+
+	if isProxyRunning() {
+		printProxyHealth()
+	} else if info, err := os.Stat(config.CrashFile); err == nil && info.Size() > 0 {
+		fmt.Println()
+		fmt.Printf("Proxy not running; its last output is in %s\n", config.CrashFile)
+	}
+
+	if len(cfg.Budgets) > 0 {
+		fmt.Println()
+		fmt.Println("=== Budgets ===")
+		now := time.Now()
+		records, err := usage.ReadLedger(config.UsageFile, usage.Since(cfg.Budgets, now))
+		if err != nil {
+			fmt.Printf("Ledger: error (%v)\n", err)
+		}
+		for _, state := range usage.NewBudgets(cfg.Budgets, records, usage.Prices(cfg.Prices), now).Snapshot(now) {
+			switch state.Level {
+			case usage.BudgetHard:
+				fmt.Printf("EXCEEDED: %s\n", state.Message())
+			case usage.BudgetSoft:
+				fmt.Printf("WARNING: %s\n", state.Message())
+			default:
+				fmt.Printf("OK: %s\n", state.Message())
+			}
+		}
+	}
 
 	fmt.Println()
 	fmt.Println("=== Auth Status ===")
-	if token, authType, err := config.GetToken(cfg); err != nil {
-		fmt.Printf("Token: error (%v)\n", err)
-	} else {
-		fmt.Printf("Token: %d chars (%s)\n", len(token), authType)
+	for _, up := range cfg.ResolveUpstreams() {
+		label := "Token"
+		if len(cfg.Upstreams) > 0 {
+			label = "Token [" + up.Name + "]"
+		}
+		if token, authType, err := config.GetToken(cfg.ForUpstream(up)); err != nil {
+			fmt.Printf("%s: error (%v)\n", label, err)
+		} else {
+			fmt.Printf("%s: %d chars (%s)\n", label, len(token), authType)
+		}
 	}
 
 	fmt.Println()
@@ -341,6 +686,47 @@ func Status() {
 	}
 }
 
+// printProxyHealth shows live per-upstream state from the running proxy.
+func printProxyHealth() {
+	fmt.Println()
+	fmt.Println("=== Proxy Health ===")
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:%d/health", getProxyPort()))
+	if err != nil {
+		fmt.Printf("Health: error (%v)\n", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	var health struct {
+		Active    string `json:"active"`
+		Upstreams []struct {
+			Name    string                 `json:"name"`
+			Circuit map[string]interface{} `json:"circuit"`
+			Queue   map[string]interface{} `json:"queue"`
+		} `json:"upstreams"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		fmt.Printf("Health: error (%v)\n", err)
+		return
+	}
+	fmt.Printf("Active: %s\n", health.Active)
+	for _, up := range health.Upstreams {
+		line := fmt.Sprintf("Circuit [%s]: %v", up.Name, up.Circuit["state"])
+		if failures, ok := up.Circuit["failures"].(float64); ok && failures > 0 {
+			line += fmt.Sprintf(" (%d consecutive failures)", int(failures))
+		}
+		if retryIn, ok := up.Circuit["retry_in_ms"].(float64); ok {
+			line += fmt.Sprintf(", probe in %v", (time.Duration(retryIn) * time.Millisecond).Round(time.Second))
+		}
+		fmt.Println(line)
+		if up.Queue != nil {
+			fmt.Printf("Queue [%s]: %v queued, %v in flight, avg wait %vms\n",
+				up.Name, up.Queue["queued"], up.Queue["in_flight"], up.Queue["avg_wait_ms"])
+		}
+	}
+}
+
 func Env(port int) {
 	fmt.Printf("export ANTHROPIC_BASE_URL=http://127.0.0.1:%d\n", port)
 }
@@ -493,7 +879,7 @@ func Claude(args []string) {
 	if authMode != "anthropic" && !isProxyRunning() {
 		port := getProxyPort()
 		fmt.Printf("Proxy not running, starting on port %d...\n", port)
-		ProxyBackground(port, "127.0.0.1", false, false)
+		ProxyBackground(port, "127.0.0.1", proxy.LogOptions{}, "")
 		// Give the proxy a moment to start
 		time.Sleep(500 * time.Millisecond)
 	}
@@ -543,6 +929,9 @@ func Claude(args []string) {
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
 	cmd.Env = os.Environ()
+	if authMode != "anthropic" {
+		cmd.Env = append(cmd.Env, "ANTHROPIC_CUSTOM_HEADERS="+projectHeaders())
+	}
 	cmd.Run()
 
 	// Restore original settings after claude exits
@@ -552,6 +941,179 @@ func Claude(args []string) {
 	}
 }
 
+// parsePrice parses "model=input,output[,cache_read,cache_write]" in USD per
+// million tokens. Cache prices default to Anthropic's usual ratios.
+func parsePrice(value string) (string, usage.Price, error) {
+	model, list, ok := strings.Cut(value, "=")
+	if !ok || model == "" {
+		return "", usage.Price{}, fmt.Errorf("expected model=input,output[,cache_read,cache_write]")
+	}
+	var nums []float64
+	for _, part := range strings.Split(list, ",") {
+		n, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return "", usage.Price{}, err
+		}
+		nums = append(nums, n)
+	}
+	if len(nums) != 2 && len(nums) != 4 {
+		return "", usage.Price{}, fmt.Errorf("expected 2 or 4 prices, got %d", len(nums))
+	}
+	price := usage.Price{Input: nums[0], Output: nums[1], CacheRead: nums[0] * 0.1, CacheWrite: nums[0] * 1.25}
+	if len(nums) == 4 {
+		price.CacheRead, price.CacheWrite = nums[2], nums[3]
+	}
+	return model, price, nil
+}
+
+// parseLimits parses "rpm=N,input_tpm=N,concurrent=N,queue_timeout=SECONDS".
+// An empty spec or "none" clears the limits.
+func parseLimits(spec string) (*config.Limits, error) {
+	if spec == "" || spec == "none" {
+		return nil, nil
+	}
+	limits := &config.Limits{}
+	for _, part := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("expected key=value, got %q", part)
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid value for %s: %q", key, value)
+		}
+		switch key {
+		case "rpm":
+			limits.RequestsPerMinute = n
+		case "input_tpm":
+			limits.InputTokensPerMinute = n
+		case "concurrent":
+			limits.MaxConcurrent = n
+		case "queue_timeout":
+			limits.QueueTimeoutSeconds = n
+		default:
+			return nil, fmt.Errorf("unknown limit %q (use rpm, input_tpm, concurrent, queue_timeout)", key)
+		}
+	}
+	return limits, nil
+}
+
+// parseTimeouts parses "connect=S,tls=S,header=S,idle=S,total=S" in
+// seconds. Unset phases keep their defaults; total=0 removes the limit.
+func parseTimeouts(spec string) (*config.TimeoutConfig, error) {
+	timeouts := &config.TimeoutConfig{}
+	for _, part := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("expected key=seconds, got %q", part)
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid value for %s: %q", key, value)
+		}
+		switch key {
+		case "connect":
+			timeouts.ConnectSeconds = n
+		case "tls":
+			timeouts.TLSHandshakeSeconds = n
+		case "header":
+			timeouts.ResponseHeaderSeconds = n
+		case "idle":
+			timeouts.IdleSeconds = n
+		case "total":
+			timeouts.TotalSeconds = &n
+		default:
+			return nil, fmt.Errorf("unknown timeout %q (use connect, tls, header, idle, total)", key)
+		}
+	}
+	return timeouts, nil
+}
+
+// parseLogRotate parses "size=MB,age=hours,keep=N,compress=on|off".
+func parseLogRotate(spec string) (*config.LogConfig, error) {
+	rotate := &config.LogConfig{}
+	for _, part := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("expected key=value, got %q", part)
+		}
+		if key == "compress" {
+			on := value == "on" || value == "true"
+			if !on && value != "off" && value != "false" {
+				return nil, fmt.Errorf("invalid value for compress: %q (use on or off)", value)
+			}
+			rotate.Compress = &on
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid value for %s: %q", key, value)
+		}
+		switch key {
+		case "size":
+			rotate.MaxSizeMB = n
+		case "age":
+			rotate.MaxAgeHours = n
+		case "keep":
+			rotate.MaxFiles = n
+		default:
+			return nil, fmt.Errorf("unknown setting %q (use size, age, keep, compress)", key)
+		}
+	}
+	return rotate, nil
+}
+
+func formatLimits(l *config.Limits) string {
+	return fmt.Sprintf("rpm=%d input_tpm=%d concurrent=%d queue_timeout=%ds",
+		l.RequestsPerMinute, l.InputTokensPerMinute, l.MaxConcurrent, l.QueueTimeoutSeconds)
+}
+
+// parseBudget parses "period[:model]=hard[,soft]" in USD. A hard cap of 0
+// with a soft cap only warns.
+func parseBudget(value string) (usage.Budget, error) {
+	key, caps, ok := strings.Cut(value, "=")
+	if !ok {
+		return usage.Budget{}, fmt.Errorf("expected period[:model]=hard[,soft]")
+	}
+	var budget usage.Budget
+	budget.Period, budget.Model, _ = strings.Cut(key, ":")
+	hard, soft, hasSoft := strings.Cut(caps, ",")
+	var err error
+	if budget.Hard, err = strconv.ParseFloat(strings.TrimSpace(hard), 64); err != nil {
+		return usage.Budget{}, err
+	}
+	if hasSoft {
+		if budget.Soft, err = strconv.ParseFloat(strings.TrimSpace(soft), 64); err != nil {
+			return usage.Budget{}, err
+		}
+	}
+	return budget, budget.Validate()
+}
+
+func removeBudget(budgets []usage.Budget, period, model string) []usage.Budget {
+	var kept []usage.Budget
+	for _, b := range budgets {
+		if b.Period != period || b.Model != model {
+			kept = append(kept, b)
+		}
+	}
+	return kept
+}
+
+// projectHeaders tags requests with the launch directory so the proxy can
+// attribute usage to a project, keeping any custom headers already set.
+func projectHeaders() string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return os.Getenv("ANTHROPIC_CUSTOM_HEADERS")
+	}
+	header := usage.ProjectHeader + ": " + cwd
+	if existing := os.Getenv("ANTHROPIC_CUSTOM_HEADERS"); existing != "" {
+		return existing + "\n" + header
+	}
+	return header
+}
+
 func Truncate(s string, n int) string {
 	if len(s) <= n {
 		return s
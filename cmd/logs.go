@@ -0,0 +1,468 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/schachte/claudecode-opencode-proxy/config"
+	"github.com/schachte/claudecode-opencode-proxy/logfile"
+)
+
+// logFilter selects log lines. Zero fields match everything.
+type logFilter struct {
+	since      time.Time
+	model      string // glob
+	status     string // "500" or a class such as "5xx"
+	errorsOnly bool
+	request    string
+}
+
+func (f logFilter) isZero() bool {
+	return f == logFilter{}
+}
+
+// ProxyLogs prints proxy log lines from the live file and its rotated
+// segments, in either the text or the JSON format. With no options it shows
+// the last 10 lines and follows the log, like tail -f.
+func ProxyLogs(args []string) {
+	var filter logFilter
+	n := -1
+	follow := len(args) == 0
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--since", "-s":
+			if i+1 < len(args) {
+				t, err := parseSince(args[i+1], time.Now())
+				if err != nil {
+					log.Fatalf("Invalid --since: %v", err)
+				}
+				filter.since = t
+				i++
+			}
+		case "--model", "-m":
+			if i+1 < len(args) {
+				if _, err := path.Match(args[i+1], ""); err != nil {
+					log.Fatalf("Invalid --model pattern %q: %v", args[i+1], err)
+				}
+				filter.model = args[i+1]
+				i++
+			}
+		case "--status":
+			if i+1 < len(args) {
+				if !validStatusFilter(args[i+1]) {
+					log.Fatalf("Invalid --status %q: expected a code (500) or class (5xx)", args[i+1])
+				}
+				filter.status = args[i+1]
+				i++
+			}
+		case "--errors-only", "-e":
+			filter.errorsOnly = true
+		case "--request", "-r":
+			if i+1 < len(args) {
+				filter.request = args[i+1]
+				i++
+			}
+		case "-n", "--lines":
+			if i+1 < len(args) {
+				v, err := strconv.Atoi(args[i+1])
+				if err != nil || v < 0 {
+					log.Fatalf("Invalid -n %q", args[i+1])
+				}
+				n = v
+				i++
+			}
+		case "-f", "--follow":
+			follow = true
+		}
+	}
+	if n < 0 {
+		// A bare listing shows a screenful; a query shows every match.
+		n = 0
+		if filter.isZero() {
+			n = 10
+		}
+	}
+
+	lines, parser, offset, err := collectLogs(config.LogFile, filter, n)
+	if err != nil {
+		log.Fatalf("Failed to read logs: %v", err)
+	}
+	for _, line := range lines {
+		fmt.Println(line.text)
+	}
+
+	if follow {
+		followLog(config.LogFile, offset, parser, filter)
+	}
+}
+
+// collectLogs returns the last n lines of name and its segments that match
+// filter (every match when n is 0), the parser state for following the log
+// and the offset reached in the live file.
+func collectLogs(name string, filter logFilter, n int) ([]logLine, *logParser, int64, error) {
+	segments, err := logfile.Segments(name)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if !filter.since.IsZero() {
+		for len(segments) > 0 {
+			if rotated, ok := logfile.SegmentTime(name, segments[0]); ok && rotated.Before(filter.since) {
+				segments = segments[1:]
+				continue
+			}
+			break
+		}
+	}
+
+	var lines []logLine
+	parser := newLogParser()
+	if n > 0 && filter.isZero() {
+		// Every line matches, so only the newest segments are needed: read
+		// back from the live file until there are n lines.
+		offset, err := readLive(name, 0, parser, filter, &lines)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, nil, 0, err
+		}
+		for i := len(segments) - 1; i >= 0 && len(lines) < n; i-- {
+			var older []logLine
+			if err := readLog(segments[i], newLogParser(), filter, &older); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %s: %v\n", segments[i], err)
+			}
+			lines = append(older, lines...)
+		}
+		return lines[max(len(lines)-n, 0):], parser, offset, nil
+	}
+
+	for _, segment := range segments {
+		if err := readLog(segment, parser, filter, &lines); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %s: %v\n", segment, err)
+		}
+	}
+	offset, err := readLive(name, 0, parser, filter, &lines)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, 0, err
+	}
+	// Request filters match every line of a request, including lines
+	// logged before its upstream request-id was known.
+	lines = parser.resolve(lines, filter)
+	if n > 0 && len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, parser, offset, nil
+}
+
+// readLog feeds a rotated segment through the parser.
+func readLog(name string, parser *logParser, filter logFilter, lines *[]logLine) error {
+	r, err := logfile.OpenSegment(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = scanLog(r, parser, filter, lines)
+	return err
+}
+
+// readLive reads the live log from offset and returns the new offset.
+func readLive(name string, offset int64, parser *logParser, filter logFilter, lines *[]logLine) (int64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return offset, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+	read, err := scanLog(f, parser, filter, lines)
+	return offset + read, err
+}
+
+// scanLog appends matching lines and returns the bytes consumed. A final
+// line without a newline is still being written, so it is left for the
+// next read.
+func scanLog(r io.Reader, parser *logParser, filter logFilter, lines *[]logLine) (int64, error) {
+	reader := bufio.NewReader(r)
+	var read int64
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				return read, nil
+			}
+			return read, err
+		}
+		read += int64(len(line))
+		line = strings.TrimRight(line, "\r\n")
+		if e := parser.parse(line); e.matches(filter) {
+			*lines = append(*lines, logLine{line, e.key})
+		}
+	}
+}
+
+// followLog polls the live file for new lines. A rotation shows up as a new
+// file at the same path; the rest of the old file is drained first.
+func followLog(name string, offset int64, parser *logParser, filter logFilter) {
+	current, _ := os.Stat(name)
+	for {
+		time.Sleep(500 * time.Millisecond)
+		info, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+		var lines []logLine
+		if current != nil && !os.SameFile(current, info) {
+			segments, _ := logfile.Segments(name)
+			if len(segments) > 0 {
+				if f, err := logfile.OpenSegment(segments[len(segments)-1]); err == nil {
+					io.CopyN(io.Discard, f, offset)
+					scanLog(f, parser, filter, &lines)
+					f.Close()
+				}
+			}
+			offset = 0
+		} else if info.Size() < offset {
+			offset = 0
+		}
+		current = info
+		offset, _ = readLive(name, offset, parser, filter, &lines)
+		for _, line := range parser.resolve(lines, filter) {
+			fmt.Println(line.text)
+		}
+	}
+}
+
+// logLine is a matched line and the request it belongs to.
+type logLine struct {
+	text, key string
+}
+
+// logEntry is what the filters need from one log line.
+type logEntry struct {
+	time   time.Time
+	level  string
+	key    string // identifies the request the line belongs to
+	model  string
+	status int
+}
+
+func (e logEntry) matches(f logFilter) bool {
+	if !f.since.IsZero() && (e.time.IsZero() || e.time.Before(f.since)) {
+		return false
+	}
+	if f.model != "" {
+		if ok, _ := path.Match(f.model, e.model); !ok {
+			return false
+		}
+	}
+	if f.status != "" && !matchStatus(f.status, e.status) {
+		return false
+	}
+	if f.errorsOnly && e.level != "warn" && e.level != "error" && e.status < 400 {
+		return false
+	}
+	if f.request != "" && e.key == "" {
+		return false
+	}
+	return true
+}
+
+func validStatusFilter(s string) bool {
+	if len(s) == 3 && strings.HasSuffix(s, "xx") && s[0] >= '1' && s[0] <= '5' {
+		return true
+	}
+	code, err := strconv.Atoi(s)
+	return err == nil && code >= 100 && code <= 599
+}
+
+func matchStatus(filter string, status int) bool {
+	if status == 0 {
+		return false
+	}
+	if strings.HasSuffix(filter, "xx") {
+		return strconv.Itoa(status)[0] == filter[0]
+	}
+	return strconv.Itoa(status) == filter
+}
+
+// logParser reads text and JSON log lines. Text lines carry the model and
+// request-id only on a request's START and DONE lines, so the parser
+// remembers them per request number and run of the proxy.
+type logParser struct {
+	run      int
+	lastTime time.Time
+	requests map[string]*logRequest // by key
+}
+
+type logRequest struct {
+	model string
+	ids   []string
+}
+
+func newLogParser() *logParser {
+	return &logParser{requests: map[string]*logRequest{}}
+}
+
+func (p *logParser) request(key string) *logRequest {
+	req, ok := p.requests[key]
+	if !ok {
+		req = &logRequest{}
+		p.requests[key] = req
+	}
+	return req
+}
+
+func (req *logRequest) addID(id string) {
+	for _, existing := range req.ids {
+		if existing == id {
+			return
+		}
+	}
+	req.ids = append(req.ids, id)
+}
+
+func (p *logParser) parse(line string) logEntry {
+	var e logEntry
+	if strings.HasPrefix(line, "{") {
+		e = p.parseJSON(line)
+	} else {
+		e = p.parseText(line)
+	}
+	if e.time.IsZero() {
+		e.time = p.lastTime
+	} else {
+		p.lastTime = e.time
+	}
+	return e
+}
+
+func (p *logParser) parseJSON(line string) logEntry {
+	var rec struct {
+		Time              time.Time `json:"time"`
+		Level             string    `json:"level"`
+		Event             string    `json:"event"`
+		Model             string    `json:"model"`
+		Status            int       `json:"status"`
+		RequestID         string    `json:"request_id"`
+		UpstreamRequestID string    `json:"upstream_request_id"`
+	}
+	if err := json.Unmarshal([]byte(line), &rec); err != nil {
+		return logEntry{}
+	}
+	if rec.Event == "listening" {
+		p.run++
+	}
+	e := logEntry{time: rec.Time, level: strings.ToLower(rec.Level), model: rec.Model, status: rec.Status}
+	if rec.RequestID != "" {
+		e.key = rec.RequestID
+		req := p.request(e.key)
+		req.addID(rec.RequestID)
+		if rec.UpstreamRequestID != "" {
+			req.addID(rec.UpstreamRequestID)
+		}
+	}
+	return e
+}
+
+// parseText reads "2006/01/02 15:04:05 [15:04:05] [DEBUG] EVENT  #N ...".
+// Banner and warning lines have no event.
+func (p *logParser) parseText(line string) logEntry {
+	var e logEntry
+	rest := line
+	if len(line) >= 20 {
+		if t, err := time.ParseInLocation("2006/01/02 15:04:05", line[:19], time.Local); err == nil {
+			e.time, rest = t, line[20:]
+		}
+	}
+	if strings.HasPrefix(line, "Proxy: http://") {
+		p.run++
+	}
+	if len(rest) < 11 || rest[0] != '[' || rest[9] != ']' {
+		e.level = "info"
+		if strings.HasPrefix(rest, "Warning") {
+			e.level = "warn"
+		}
+		return e
+	}
+	rest = rest[11:]
+	debug := strings.HasPrefix(rest, "[DEBUG] ")
+	rest = strings.TrimPrefix(rest, "[DEBUG] ")
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return e
+	}
+	e.level = textLevel(fields[0], rest, debug)
+
+	if len(fields) < 2 || !strings.HasPrefix(fields[1], "#") {
+		return e
+	}
+	num, err := strconv.Atoi(fields[1][1:])
+	if err != nil {
+		return e
+	}
+	e.key = fmt.Sprintf("%d#%d", p.run, num)
+	req := p.request(e.key)
+	for _, field := range fields[2:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "model":
+			req.model = value
+		case "id":
+			req.addID(value)
+		case "status":
+			e.status = leadingInt(value)
+		}
+	}
+	e.model = req.model
+	return e
+}
+
+// textLevel recovers a text line's level from its event name.
+func textLevel(event, line string, debug bool) string {
+	switch {
+	case debug:
+		return "debug"
+	case event == "ERROR":
+		return "error"
+	case event == "RETRY", event == "FAIL", event == "SKIP", event == "BUDGET",
+		event == "CIRCUIT" && strings.HasSuffix(line, " open"):
+		return "warn"
+	}
+	return "info"
+}
+
+func leadingInt(s string) int {
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	n, _ := strconv.Atoi(s[:end])
+	return n
+}
+
+// resolve applies the request filter to lines that passed the other
+// filters, using every request-id seen so far.
+func (p *logParser) resolve(lines []logLine, filter logFilter) []logLine {
+	if filter.request == "" {
+		return lines
+	}
+	var out []logLine
+	for _, line := range lines {
+		for _, id := range p.requests[line.key].ids {
+			if id == filter.request {
+				out = append(out, line)
+				break
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,264 @@
+package cmd
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogParser(t *testing.T) {
+	at := func(hour, min, sec int) time.Time {
+		return time.Date(2026, 10, 16, hour, min, sec, 0, time.Local)
+	}
+	// Lines are parsed in order, so later entries can rely on what earlier
+	// ones taught the parser.
+	tests := []struct {
+		line string
+		want logEntry
+	}{
+		{"Proxy: http://127.0.0.1:8080 -> https://api.example.com", logEntry{level: "info"}},
+		{"2026/10/16 08:00:00 [08:00:00] START  #1 [stream] model=claude-sonnet-4-5 id=req_proxy_a",
+			logEntry{time: at(8, 0, 0), level: "info", key: "1#1", model: "claude-sonnet-4-5"}},
+		{"2026/10/16 08:00:01 [08:00:01] RETRY  #1 [primary] attempt 1/3 failed (status 529), retrying in 1s",
+			logEntry{time: at(8, 0, 1), level: "warn", key: "1#1", model: "claude-sonnet-4-5"}},
+		{"2026/10/16 08:00:02 [08:00:02] [DEBUG] STREAM #1 write error: broken pipe",
+			logEntry{time: at(8, 0, 2), level: "debug", key: "1#1", model: "claude-sonnet-4-5"}},
+		{"2026/10/16 08:00:03 [08:00:03] DONE   #1 [stream] status=200 id=req_up_a 10B 3s",
+			logEntry{time: at(8, 0, 3), level: "info", key: "1#1", model: "claude-sonnet-4-5", status: 200}},
+		{"2026/10/16 08:00:04 [08:00:04] ERROR  #2 all upstream circuits open",
+			logEntry{time: at(8, 0, 4), level: "error", key: "1#2"}},
+		{"2026/10/16 08:00:05 [08:00:05] CIRCUIT [backup] open", logEntry{time: at(8, 0, 5), level: "warn"}},
+		{"2026/10/16 08:00:06 [08:00:06] CIRCUIT [backup] closed", logEntry{time: at(8, 0, 6), level: "info"}},
+		{"2026/10/16 08:00:07 [08:00:07] BUDGET WARN daily $8.00 of $10.00", logEntry{time: at(8, 0, 7), level: "warn"}},
+		{"Warning: failed to compress proxy.log.20261016-080000", logEntry{time: at(8, 0, 7), level: "warn"}},
+
+		// A JSON run starts over at request #1.
+		{`{"time":"2026-10-16T09:00:00Z","level":"INFO","msg":"Proxy: http://127.0.0.1:8080","event":"listening"}`,
+			logEntry{time: time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC), level: "info"}},
+		{`{"time":"2026-10-16T09:00:01Z","level":"WARN","msg":"#1 [primary] status=503","event":"fail","request":1,"request_id":"req_proxy_b","model":"claude-opus-4-1","status":503}`,
+			logEntry{time: time.Date(2026, 10, 16, 9, 0, 1, 0, time.UTC), level: "warn", key: "req_proxy_b", model: "claude-opus-4-1", status: 503}},
+		{`{"time":"2026-10-16T09:00:02Z","level":"INFO","msg":"#1 [sync] status=200","event":"done","request":1,"request_id":"req_proxy_b","upstream_request_id":"req_up_b","status":200}`,
+			logEntry{time: time.Date(2026, 10, 16, 9, 0, 2, 0, time.UTC), level: "info", key: "req_proxy_b", status: 200}},
+		{`{not json`, logEntry{time: time.Date(2026, 10, 16, 9, 0, 2, 0, time.UTC)}},
+
+		// So does a text run.
+		{"Proxy: http://127.0.0.1:8080 -> https://api.example.com", logEntry{time: time.Date(2026, 10, 16, 9, 0, 2, 0, time.UTC), level: "info"}},
+		{"2026/10/16 10:00:00 [10:00:00] START  #1 [sync] id=req_proxy_c",
+			logEntry{time: at(10, 0, 0), level: "info", key: "3#1"}},
+	}
+	parser := newLogParser()
+	for i, tt := range tests {
+		if got := parser.parse(tt.line); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("line %d %q:\ngot  %+v\nwant %+v", i, tt.line, got, tt.want)
+		}
+	}
+
+	ids := map[string][]string{
+		"1#1":         {"req_proxy_a", "req_up_a"},
+		"req_proxy_b": {"req_proxy_b", "req_up_b"},
+		"3#1":         {"req_proxy_c"},
+	}
+	for key, want := range ids {
+		if got := parser.requests[key].ids; !reflect.DeepEqual(got, want) {
+			t.Errorf("ids of %s = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestLogEntryMatches(t *testing.T) {
+	noon := time.Date(2026, 10, 16, 12, 0, 0, 0, time.Local)
+	done := logEntry{time: noon, level: "info", key: "1#1", model: "claude-sonnet-4-5", status: 200}
+	failed := logEntry{time: noon, level: "info", key: "1#2", model: "claude-haiku-4-5", status: 503}
+	retry := logEntry{time: noon, level: "warn", key: "1#3", model: "claude-sonnet-4-5"}
+	banner := logEntry{level: "info"}
+	tests := []struct {
+		name   string
+		entry  logEntry
+		filter logFilter
+		want   bool
+	}{
+		{"no filter", banner, logFilter{}, true},
+		{"since before", done, logFilter{since: noon.Add(-time.Second)}, true},
+		{"since equal", done, logFilter{since: noon}, true},
+		{"since after", done, logFilter{since: noon.Add(time.Second)}, false},
+		{"since without time", banner, logFilter{since: noon}, false},
+		{"model glob", done, logFilter{model: "claude-sonnet-*"}, true},
+		{"model mismatch", failed, logFilter{model: "claude-sonnet-*"}, false},
+		{"status code", failed, logFilter{status: "503"}, true},
+		{"status class", failed, logFilter{status: "5xx"}, true},
+		{"status class mismatch", done, logFilter{status: "5xx"}, false},
+		{"status without status", retry, logFilter{status: "5xx"}, false},
+		{"errors-only by status", failed, logFilter{errorsOnly: true}, true},
+		{"errors-only by level", retry, logFilter{errorsOnly: true}, true},
+		{"errors-only ok", done, logFilter{errorsOnly: true}, false},
+		{"request needs a key", banner, logFilter{request: "req_x"}, false},
+		{"request with a key", done, logFilter{request: "req_x"}, true}, // resolved later
+	}
+	for _, tt := range tests {
+		if got := tt.entry.matches(tt.filter); got != tt.want {
+			t.Errorf("%s: matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidStatusFilter(t *testing.T) {
+	for s, want := range map[string]bool{
+		"500": true, "5xx": true, "1xx": true, "6xx": false, "099": false,
+		"600": false, "5XX": false, "xx": false, "": false,
+	} {
+		if got := validStatusFilter(s); got != want {
+			t.Errorf("validStatusFilter(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
+
+// logDir copies testdata/logs to a temporary directory, compressing the
+// newest rotated segment, and returns the live log's path.
+func logDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range []string{"proxy.log", "proxy.log.20261015-120000", "proxy.log.20261016-090000"} {
+		data, err := os.ReadFile(filepath.Join("testdata", "logs", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != "proxy.log.20261016-090000" {
+			if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		f, err := os.Create(filepath.Join(dir, name+".gz"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		zw := gzip.NewWriter(f)
+		zw.Write(data)
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	return filepath.Join(dir, "proxy.log")
+}
+
+func TestCollectLogs(t *testing.T) {
+	path := logDir(t)
+	tests := []struct {
+		name   string
+		filter logFilter
+		n      int
+		want   []string // line prefixes after the date; "" is a blank line
+	}{
+		{"tail of the live file", logFilter{}, 2, []string{
+			"[09:30:01] DONE   #1",
+			"[09:31:00] CIRCUIT [backup] open",
+		}},
+		{"tail across segments", logFilter{}, 5, []string{
+			"",
+			"[08:30:00] START  #1",
+			"[09:30:00] [DEBUG] STREAM #1",
+			"[09:30:01] DONE   #1",
+			"[09:31:00] CIRCUIT [backup] open",
+		}},
+		{"since across segments", logFilter{since: time.Date(2026, 10, 16, 8, 0, 1, 0, time.Local)}, 0, []string{
+			"[08:00:01] ERROR  #2",
+			"[08:00:01] DONE   #2",
+			"Proxy: http://", // no time of its own
+			"Auth: bearer",
+			"",
+			"[08:30:00] START  #1",
+			"[09:30:00] [DEBUG] STREAM #1",
+			"[09:30:01] DONE   #1",
+			"[09:31:00] CIRCUIT [backup] open",
+		}},
+		{"status class", logFilter{status: "5xx"}, 0, []string{
+			"[08:00:01] DONE   #2 [stream] status=502",
+		}},
+		{"errors only", logFilter{errorsOnly: true}, 0, []string{
+			"[11:00:01] RETRY  #1",
+			"[08:00:01] ERROR  #2",
+			"[08:00:01] DONE   #2",
+			"[09:31:00] CIRCUIT [backup] open",
+		}},
+		{"errors only, last", logFilter{errorsOnly: true}, 1, []string{
+			"[09:31:00] CIRCUIT [backup] open",
+		}},
+		{"model carried from START", logFilter{model: "claude-haiku-*"}, 0, []string{
+			"[08:00:00] START  #2",
+			"[08:00:01] ERROR  #2",
+			"[08:00:01] DONE   #2",
+		}},
+		// The upstream id is only logged on DONE; the same request number
+		// in the next run is a different request.
+		{"upstream request id", logFilter{request: "req_up_a1"}, 0, []string{
+			"[11:00:00] START  #1",
+			"[11:00:01] RETRY  #1",
+			"[11:00:03] DONE   #1",
+		}},
+		{"request id across segments", logFilter{request: "req_up_c1"}, 0, []string{
+			"[08:30:00] START  #1",
+			"[09:30:00] [DEBUG] STREAM #1",
+			"[09:30:01] DONE   #1",
+		}},
+		{"proxy request id", logFilter{request: "req_proxy_b2"}, 0, []string{
+			"[08:00:00] START  #2",
+			"[08:00:01] ERROR  #2",
+			"[08:00:01] DONE   #2",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, _, offset, err := collectLogs(path, tt.filter, tt.n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info, _ := os.Stat(path); offset != info.Size() {
+				t.Errorf("offset = %d, want %d", offset, info.Size())
+			}
+			var got []string
+			for _, line := range lines {
+				got = append(got, line.text)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d lines, want %d:\n%s", len(got), len(tt.want), strings.Join(got, "\n"))
+			}
+			for i, prefix := range tt.want {
+				text := got[i]
+				if _, err := time.Parse("2006/01/02 15:04:05 ", text[:min(len(text), 20)]); err == nil {
+					text = text[20:]
+				}
+				if !strings.HasPrefix(text, prefix) || prefix == "" && text != "" {
+					t.Errorf("line %d = %q, want prefix %q", i, got[i], prefix)
+				}
+			}
+		})
+	}
+}
+
+func TestCollectLogsTailReadsOnlyNewestSegments(t *testing.T) {
+	path := logDir(t)
+	// An unreadable oldest segment is only reported if it is read.
+	os.WriteFile(path+".20261014-000000.gz", []byte("not gzip"), 0644)
+	stderr := os.Stderr
+	r, w, _ := os.Pipe()
+	os.Stderr = w
+	lines, _, _, err := collectLogs(path, logFilter{}, 5)
+	os.Stderr = stderr
+	w.Close()
+	warnings, _ := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 5 {
+		t.Errorf("got %d lines, want 5", len(lines))
+	}
+	if len(warnings) > 0 {
+		t.Errorf("read older segments than needed: %s", warnings)
+	}
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/schachte/claudecode-opencode-proxy/mockupstream"
+)
+
+// MockUpstream serves a fake Anthropic Messages API for exercising the proxy
+// offline: retries, failover, the circuit breaker and truncated streams.
+func MockUpstream(args []string) {
+	port := 9999
+	bindAddr := "127.0.0.1"
+	server := &mockupstream.Server{Log: log.Printf}
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--port", "-p":
+			if i+1 < len(args) {
+				p, err := strconv.Atoi(args[i+1])
+				if err != nil {
+					log.Fatalf("Invalid --port %q", args[i+1])
+				}
+				port = p
+				i++
+			}
+		case "--bind", "-b":
+			if i+1 < len(args) {
+				bindAddr = args[i+1]
+				i++
+			}
+		case "--fixtures":
+			if i+1 < len(args) {
+				fixtures, err := mockupstream.LoadFixtures(args[i+1])
+				if err != nil {
+					log.Fatalf("Failed to load fixtures: %v", err)
+				}
+				server.Fixtures = append(server.Fixtures, fixtures...)
+				i++
+			}
+		case "--text":
+			if i+1 < len(args) {
+				server.Text = args[i+1]
+				i++
+			}
+		case "--fault":
+			if i+1 < len(args) {
+				fault, err := mockupstream.ParseFault(args[i+1])
+				if err != nil {
+					log.Fatalf("Invalid --fault %q: %v", args[i+1], err)
+				}
+				server.Faults = append(server.Faults, fault)
+				i++
+			}
+		case "--chunk-delay":
+			if i+1 < len(args) {
+				d, err := time.ParseDuration(args[i+1])
+				if err != nil {
+					log.Fatalf("Invalid --chunk-delay %q: %v", args[i+1], err)
+				}
+				server.ChunkDelay = d
+				i++
+			}
+		default:
+			log.Fatalf("Unknown option for mock-upstream: %s", args[i])
+		}
+	}
+
+	addr := net.JoinHostPort(bindAddr, strconv.Itoa(port))
+	fmt.Printf("Mock upstream: http://%s\n", addr)
+	fmt.Printf("Fixtures: %d, faults: %d\n", len(server.Fixtures), len(server.Faults))
+	fmt.Printf("Point the proxy at it with: claude-opencode-proxy config --target http://%s --auth-type apikey --api-key test --no-cf-access\n", addr)
+	if err := http.ListenAndServe(addr, server); err != nil {
+		log.Fatalf("Mock upstream failed: %v", err)
+	}
+}
@@ -0,0 +1,311 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/schachte/claudecode-opencode-proxy/capture"
+	"github.com/schachte/claudecode-opencode-proxy/config"
+)
+
+// Replay resends a captured request and diffs the new response against the
+// captured one. By default the client's request is sent to the local proxy;
+// --upstream sends the rewritten upstream request instead.
+func Replay(args []string) {
+	dir := config.CaptureDir
+	target := "http://127.0.0.1:8787"
+	upstream := false
+	var ref string
+	extra := http.Header{}
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--dir", "-d":
+			if i+1 < len(args) {
+				dir = args[i+1]
+				i++
+			}
+		case "--target", "-t":
+			if i+1 < len(args) {
+				target = strings.TrimRight(args[i+1], "/")
+				i++
+			}
+		case "--upstream", "-u":
+			upstream = true
+		case "--header", "-H":
+			if i+1 < len(args) {
+				name, value, ok := strings.Cut(args[i+1], ":")
+				if !ok {
+					log.Fatalf("Invalid header %q, expected \"Name: value\"", args[i+1])
+				}
+				extra.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+				i++
+			}
+		default:
+			ref = args[i]
+		}
+	}
+	if ref == "" {
+		fmt.Fprintln(os.Stderr, "Usage: claude-opencode-proxy replay <request-id|file> [--target url] [--upstream] [-H \"Name: value\"]")
+		os.Exit(1)
+	}
+
+	path := ref
+	if _, err := os.Stat(path); err != nil {
+		path = capture.Path(dir, ref)
+	}
+	exchange, err := capture.Load(path)
+	if err != nil {
+		log.Fatalf("Failed to load capture: %v", err)
+	}
+
+	sent := exchange.Request
+	if upstream {
+		if exchange.Upstream == nil {
+			log.Fatalf("Capture %s has no upstream request", exchange.ID)
+		}
+		sent = exchange.Upstream.Request
+	}
+	dest := target + sent.URL
+	if upstream {
+		// Keep the upstream's path and query, sent to the chosen target.
+		u, err := url.Parse(sent.URL)
+		if err != nil {
+			log.Fatalf("Invalid upstream URL in capture: %v", err)
+		}
+		dest = target + u.RequestURI()
+	}
+
+	req, err := http.NewRequest(sent.Method, dest, bytes.NewReader(sent.Body))
+	if err != nil {
+		log.Fatalf("Failed to create request: %v", err)
+	}
+	for name, values := range sent.Header {
+		if skipReplayHeader(name, values) {
+			continue
+		}
+		req.Header[name] = values
+	}
+	for name, values := range extra {
+		req.Header[name] = values
+	}
+
+	fmt.Printf("Replaying %s (%s) -> %s\n", exchange.ID, exchange.Model, dest)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatalf("Failed to read response: %v", err)
+	}
+
+	fmt.Printf("Status: %d -> %d\n", exchange.Response.Status, resp.StatusCode)
+	before := normalizeResponse(exchange.Response.Body)
+	after := normalizeResponse(body)
+	changed := printDiff(os.Stdout, before, after)
+	if !changed && exchange.Response.Status == resp.StatusCode {
+		fmt.Println("No differences")
+		return
+	}
+	os.Exit(1)
+}
+
+// skipReplayHeader drops redacted values and headers the HTTP client sets
+// itself.
+func skipReplayHeader(name string, values []string) bool {
+	switch strings.ToLower(name) {
+	case "content-length", "host", "connection", "accept-encoding":
+		return true
+	}
+	return len(values) == 1 && values[0] == capture.Redacted
+}
+
+// normalizeResponse renders a response body as lines that are stable across
+// runs: SSE deltas are joined per content block, JSON is indented with
+// sorted keys, and message and request ids are masked.
+func normalizeResponse(body []byte) []string {
+	trimmed := bytes.TrimSpace(body)
+	if bytes.HasPrefix(trimmed, []byte("event:")) || bytes.HasPrefix(trimmed, []byte("data:")) {
+		return normalizeSSE(trimmed)
+	}
+	var v interface{}
+	if err := json.Unmarshal(trimmed, &v); err == nil {
+		return strings.Split(canonicalJSON(maskIDs(v), "  "), "\n")
+	}
+	return strings.Split(string(trimmed), "\n")
+}
+
+func normalizeSSE(body []byte) []string {
+	var lines []string
+	blocks := map[int]*strings.Builder{}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	event := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "event:") {
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+			continue
+		}
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		var v map[string]interface{}
+		if json.Unmarshal([]byte(data), &v) != nil {
+			lines = append(lines, event+": "+data)
+			continue
+		}
+		index := 0
+		if i, ok := v["index"].(float64); ok {
+			index = int(i)
+		}
+		switch event {
+		case "ping":
+		case "content_block_delta":
+			delta, _ := v["delta"].(map[string]interface{})
+			b, ok := blocks[index]
+			if !ok {
+				b = &strings.Builder{}
+				blocks[index] = b
+			}
+			for _, key := range []string{"text", "partial_json", "thinking"} {
+				if s, ok := delta[key].(string); ok {
+					b.WriteString(s)
+				}
+			}
+		case "content_block_stop":
+			if b, ok := blocks[index]; ok {
+				lines = append(lines, fmt.Sprintf("content_block[%d]: %s", index, canonicalJSON(b.String(), "")))
+				delete(blocks, index)
+			}
+			lines = append(lines, event+": "+canonicalJSON(maskIDs(v), ""))
+		default:
+			lines = append(lines, event+": "+canonicalJSON(maskIDs(v), ""))
+		}
+	}
+	return lines
+}
+
+// maskIDs replaces per-response identifiers so they don't show as changes.
+func maskIDs(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if key == "id" || key == "request_id" {
+				if _, ok := value.(string); ok {
+					v[key] = "<id>"
+					continue
+				}
+			}
+			v[key] = maskIDs(value)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = maskIDs(v[i])
+		}
+	}
+	return v
+}
+
+// canonicalJSON marshals with sorted keys, which encoding/json already does
+// for maps, leaving characters such as < unescaped.
+func canonicalJSON(v interface{}, indent string) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", indent)
+	enc.Encode(v)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// printDiff writes a line diff with three lines of context around changes
+// to w and reports whether anything changed.
+func printDiff(w io.Writer, a, b []string) bool {
+	// Longest common subsequence table, filled from the end.
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	type op struct {
+		kind byte
+		line string
+	}
+	var ops []op
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, op{' ', a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, op{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, op{'+', b[j]})
+			j++
+		}
+	}
+
+	const context = 3
+	var changes []int
+	for k, o := range ops {
+		if o.kind != ' ' {
+			changes = append(changes, k)
+		}
+	}
+	if len(changes) == 0 {
+		return false
+	}
+	show := map[int]bool{}
+	for _, k := range changes {
+		for c := k - context; c <= k+context; c++ {
+			show[c] = true
+		}
+	}
+	keys := make([]int, 0, len(show))
+	for k := range show {
+		if k >= 0 && k < len(ops) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Ints(keys)
+	prev := -1
+	for _, k := range keys {
+		if prev >= 0 && k != prev+1 {
+			fmt.Fprintln(w, "  ...")
+		}
+		o := ops[k]
+		switch o.kind {
+		case '-':
+			fmt.Fprintf(w, "\033[31m- %s\033[0m\n", o.line)
+		case '+':
+			fmt.Fprintf(w, "\033[32m+ %s\033[0m\n", o.line)
+		default:
+			fmt.Fprintf(w, "  %s\n", o.line)
+		}
+		prev = k
+	}
+	return true
+}
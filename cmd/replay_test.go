@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const toolStream = `event: message_start
+data: {"type":"message_start","message":{"id":"msg_01","model":"claude-sonnet-4-5","role":"assistant","content":[]}}
+
+event: ping
+data: {"type":"ping"}
+
+event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":""}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"Let me "}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"check <b>."}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":0}
+
+event: content_block_start
+data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_01","name":"get_weather","input":{}}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"Paris\"}"}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":1}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":12}}
+
+event: message_stop
+data: {"type":"message_stop"}
+
+event: error
+data: not json
+`
+
+func TestNormalizeSSE(t *testing.T) {
+	want := []string{
+		`message_start: {"message":{"content":[],"id":"<id>","model":"claude-sonnet-4-5","role":"assistant"},"type":"message_start"}`,
+		`content_block_start: {"content_block":{"thinking":"","type":"thinking"},"index":0,"type":"content_block_start"}`,
+		`content_block[0]: "Let me check <b>."`,
+		`content_block_stop: {"index":0,"type":"content_block_stop"}`,
+		`content_block_start: {"content_block":{"id":"<id>","input":{},"name":"get_weather","type":"tool_use"},"index":1,"type":"content_block_start"}`,
+		`content_block[1]: "{\"city\":\"Paris\"}"`,
+		`content_block_stop: {"index":1,"type":"content_block_stop"}`,
+		`message_delta: {"delta":{"stop_reason":"tool_use"},"type":"message_delta","usage":{"output_tokens":12}}`,
+		`message_stop: {"type":"message_stop"}`,
+		`error: not json`,
+	}
+	got := normalizeResponse([]byte(toolStream))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("normalizeSSE:\ngot:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// The same response chunked differently, with new ids, a ping in
+	// another place and CRLF line endings, is unchanged.
+	rechunked := strings.NewReplacer(
+		`"thinking":"Let me "`, `"thinking":"Let"`,
+		`"thinking":"check <b>."`, `"thinking":" me check <b>."`,
+		`msg_01`, `msg_02`,
+		`toolu_01`, `toolu_02`,
+		"event: ping\ndata: {\"type\":\"ping\"}\n\n", "",
+		"event: message_stop\n", "event: ping\ndata: {\"type\":\"ping\"}\n\nevent: message_stop\n",
+		"\n", "\r\n",
+	).Replace(toolStream)
+	if again := normalizeResponse([]byte(rechunked)); !reflect.DeepEqual(again, got) {
+		t.Errorf("rechunked stream normalized differently:\n%s", strings.Join(again, "\n"))
+	}
+}
+
+func TestNormalizeResponse(t *testing.T) {
+	tests := []struct {
+		body string
+		want []string
+	}{
+		{`{"type":"message","id":"msg_01","content":[{"type":"tool_use","id":"toolu_01","name":"a&b"}],"usage":{"request_id":"req_1","input_tokens":3}}`, []string{
+			`{`,
+			`  "content": [`,
+			`    {`,
+			`      "id": "<id>",`,
+			`      "name": "a&b",`,
+			`      "type": "tool_use"`,
+			`    }`,
+			`  ],`,
+			`  "id": "<id>",`,
+			`  "type": "message",`,
+			`  "usage": {`,
+			`    "input_tokens": 3,`,
+			`    "request_id": "<id>"`,
+			`  }`,
+			`}`,
+		}},
+		{"\n<html>\nBad Gateway\n</html>\n", []string{"<html>", "Bad Gateway", "</html>"}},
+		{`{"id":7}`, []string{`{`, `  "id": 7`, `}`}}, // only string ids are masked
+	}
+	for _, tt := range tests {
+		if got := normalizeResponse([]byte(tt.body)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("normalizeResponse(%q):\ngot:\n%s\nwant:\n%s", tt.body, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
+
+func TestPrintDiff(t *testing.T) {
+	del := func(s string) string { return "\033[31m- " + s + "\033[0m" }
+	add := func(s string) string { return "\033[32m+ " + s + "\033[0m" }
+	same := func(s string) string { return "  " + s }
+	numbers := func(from, to int) []string {
+		var lines []string
+		for i := from; i <= to; i++ {
+			lines = append(lines, strings.Repeat("x", i))
+		}
+		return lines
+	}
+	replace := func(lines []string, at map[int]string) []string {
+		out := append([]string{}, lines...)
+		for i, s := range at {
+			out[i] = s
+		}
+		return out
+	}
+
+	tests := []struct {
+		name string
+		a, b []string
+		want []string
+	}{
+		{"identical", numbers(1, 5), numbers(1, 5), nil},
+		{"both empty", nil, nil, nil},
+		{"from nothing", nil, []string{"a", "b"}, []string{add("a"), add("b")}},
+		{"to nothing", []string{"a"}, nil, []string{del("a")}},
+		{"shifted", []string{"a", "b", "c", "d"}, []string{"b", "c", "d", "e"}, []string{
+			del("a"), same("b"), same("c"), same("d"), add("e"),
+		}},
+		{"context", numbers(1, 10), replace(numbers(1, 10), map[int]string{4: "changed"}), []string{
+			same("xx"), same("xxx"), same("xxxx"),
+			del("xxxxx"), add("changed"),
+			same("xxxxxx"), same("xxxxxxx"), same("xxxxxxxx"),
+		}},
+		{"two hunks", numbers(1, 20), replace(numbers(1, 20), map[int]string{1: "A", 17: "B"}), []string{
+			same("x"), del("xx"), add("A"), same("xxx"), same("xxxx"), same("xxxxx"),
+			"  ...",
+			same(strings.Repeat("x", 15)), same(strings.Repeat("x", 16)), same(strings.Repeat("x", 17)),
+			del(strings.Repeat("x", 18)), add("B"),
+			same(strings.Repeat("x", 19)), same(strings.Repeat("x", 20)),
+		}},
+		// Six unchanged lines between changes are all context, so nothing
+		// is elided.
+		{"adjacent hunks merge", numbers(1, 8), replace(numbers(1, 8), map[int]string{0: "A", 7: "B"}), []string{
+			del("x"), add("A"),
+			same("xx"), same("xxx"), same("xxxx"), same("xxxxx"), same("xxxxxx"), same("xxxxxxx"),
+			del("xxxxxxxx"), add("B"),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			changed := printDiff(&out, tt.a, tt.b)
+			if changed != (tt.want != nil) {
+				t.Errorf("changed = %v", changed)
+			}
+			var want string
+			if tt.want != nil {
+				want = strings.Join(tt.want, "\n") + "\n"
+			}
+			if out.String() != want {
+				t.Errorf("diff:\n%s\nwant:\n%s", out.String(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/schachte/claudecode-opencode-proxy/config"
+	"github.com/schachte/claudecode-opencode-proxy/proxy"
+)
+
+func ConfigRules(args []string) {
+	cfg := config.LoadConfig()
+
+	if len(args) == 0 || args[0] == "list" {
+		printRules(cfg.Rules)
+		return
+	}
+
+	switch args[0] {
+	case "test":
+		testRules(cfg, args[1:])
+		return
+
+	case "add":
+		rule := config.RewriteRule{}
+		hasValue := false
+		rest := args[1:]
+		for i := 0; i < len(rest); i++ {
+			if i+1 >= len(rest) {
+				break
+			}
+			switch rest[i] {
+			case "--name":
+				rule.Name = rest[i+1]
+			case "--action":
+				rule.Action = rest[i+1]
+			case "--field":
+				rule.Field = rest[i+1]
+			case "--to":
+				rule.To = rest[i+1]
+			case "--value":
+				hasValue = true
+				// Accept JSON values; anything else is taken as a string.
+				if err := json.Unmarshal([]byte(rest[i+1]), &rule.Value); err != nil {
+					rule.Value = rest[i+1]
+				}
+			case "--model":
+				rule.Match.Model = rest[i+1]
+			case "--path":
+				rule.Match.Path = rest[i+1]
+			case "--upstream":
+				rule.Match.Upstream = rest[i+1]
+			default:
+				continue
+			}
+			i++
+		}
+		switch rule.Action {
+		case "delete", "set", "rename":
+		default:
+			log.Fatalf("Invalid action %q (use delete, set or rename)", rule.Action)
+		}
+		if rule.Field == "" || (rule.Action == "rename" && rule.To == "") {
+			log.Fatalf("Rule needs --field (and --to for rename)")
+		}
+		if rule.Action == "set" && !hasValue {
+			log.Fatalf("A set rule needs --value (use --value null to set null)")
+		}
+		if rule.Action != "set" && hasValue {
+			log.Fatalf("--value only applies to set rules")
+		}
+		cfg.Rules = append(cfg.Rules, rule)
+
+	case "remove", "rm":
+		if len(args) < 2 {
+			log.Fatalf("Usage: config rules remove <number>")
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 || n > len(cfg.Rules) {
+			log.Fatalf("Invalid rule number: %s", args[1])
+		}
+		cfg.Rules = append(cfg.Rules[:n-1], cfg.Rules[n:]...)
+
+	case "reset":
+		cfg.Rules = config.DefaultRules()
+
+	default:
+		log.Fatalf("Unknown rules command: %s", args[0])
+	}
+
+	if err := config.SaveConfig(cfg); err != nil {
+		log.Fatalf("Failed to save config: %v", err)
+	}
+	fmt.Println("Rules saved:")
+	printRules(cfg.Rules)
+}
+
+func printRules(rules []config.RewriteRule) {
+	if len(rules) == 0 {
+		fmt.Println("No rewrite rules configured")
+		return
+	}
+	for i, rule := range rules {
+		desc := fmt.Sprintf("%s %s", rule.Action, rule.Field)
+		switch rule.Action {
+		case "set":
+			value, _ := json.Marshal(rule.Value)
+			desc += " = " + string(value)
+		case "rename":
+			desc += " -> " + rule.To
+		}
+		if rule.Match.Model != "" {
+			desc += fmt.Sprintf(" [model=%s]", rule.Match.Model)
+		}
+		if rule.Match.Path != "" {
+			desc += fmt.Sprintf(" [path=%s]", rule.Match.Path)
+		}
+		if rule.Match.Upstream != "" {
+			desc += fmt.Sprintf(" [upstream=%s]", rule.Match.Upstream)
+		}
+		name := rule.Name
+		if name == "" {
+			name = "rule-" + strconv.Itoa(i+1)
+		}
+		fmt.Printf("%2d. %-28s %s\n", i+1, name, desc)
+	}
+}
+
+func testRules(cfg config.Config, args []string) {
+	reqPath := "/v1/messages"
+	upstream := cfg.ResolveUpstreams()[0].Name
+	source := "-"
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--path" && i+1 < len(args) {
+			reqPath = args[i+1]
+			i++
+		} else if args[i] == "--upstream" && i+1 < len(args) {
+			upstream = args[i+1]
+			i++
+		} else {
+			source = args[i]
+		}
+	}
+
+	var data []byte
+	var err error
+	if source == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(source)
+	}
+	if err != nil {
+		log.Fatalf("Failed to read sample body: %v", err)
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		log.Fatalf("Sample body is not a JSON object: %v", err)
+	}
+
+	applied := proxy.ApplyRules(cfg.Rules, reqPath, upstream, body)
+	if len(applied) == 0 {
+		fmt.Fprintln(os.Stderr, "No rules matched")
+	} else {
+		for _, name := range applied {
+			fmt.Fprintf(os.Stderr, "Applied: %s\n", name)
+		}
+	}
+	output, _ := json.MarshalIndent(body, "", "  ")
+	fmt.Println(string(output))
+}
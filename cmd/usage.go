@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/schachte/claudecode-opencode-proxy/config"
+	"github.com/schachte/claudecode-opencode-proxy/usage"
+)
+
+func Usage(args []string) {
+	var since time.Time
+	var by []string
+	format := "text"
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--since", "-s":
+			if i+1 < len(args) {
+				t, err := parseSince(args[i+1], time.Now())
+				if err != nil {
+					log.Fatalf("Invalid --since: %v", err)
+				}
+				since = t
+				i++
+			}
+		case "--by", "-b":
+			if i+1 < len(args) {
+				by = strings.Split(args[i+1], ",")
+				i++
+			}
+		case "--json", "-j":
+			format = "json"
+		case "--csv":
+			format = "csv"
+		}
+	}
+
+	records, err := usage.ReadLedger(config.UsageFile, since)
+	if err != nil {
+		log.Fatalf("Failed to read usage ledger: %v", err)
+	}
+
+	if len(records) == 0 && format == "text" {
+		fmt.Printf("No usage recorded in %s\n", config.UsageFile)
+		return
+	}
+	prices := usage.Prices(config.LoadConfig().Prices)
+	if err := writeUsage(os.Stdout, records, prices, by, format); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// writeUsage prints a report of records grouped by by, as a text table
+// (one per default grouping when by is nil), JSON or CSV.
+func writeUsage(w io.Writer, records []usage.Record, prices usage.PriceTable, by []string, format string) error {
+	// Records written before pricing existed are priced with today's table.
+	for i, rec := range records {
+		if rec.CostUSD == 0 && !rec.Usage.IsZero() {
+			records[i].CostUSD = prices.ServedCost(rec.Model, rec.UpstreamModel, rec.Usage)
+		}
+	}
+
+	if format != "text" {
+		if by == nil {
+			by = []string{"day", "model", "project"}
+		}
+		rows, err := usage.Summarize(records, by)
+		if err != nil {
+			return err
+		}
+		if format == "json" {
+			output, _ := json.MarshalIndent(rows, "", "  ")
+			fmt.Fprintln(w, string(output))
+		} else {
+			writeUsageCSV(w, rows, by)
+		}
+		return nil
+	}
+
+	groups := [][]string{{"day"}, {"model"}, {"project"}}
+	if by != nil {
+		groups = [][]string{by}
+	}
+	for i, group := range groups {
+		rows, err := usage.Summarize(records, group)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "=== By %s ===\n", strings.Join(group, ", "))
+		printUsageTable(w, rows, group)
+	}
+	return nil
+}
+
+func printUsageTable(w io.Writer, rows []usage.Row, by []string) {
+	keyWidth := 0
+	labels := make([]string, len(rows))
+	for i, row := range rows {
+		parts := make([]string, len(by))
+		for j, key := range by {
+			parts[j] = row.Keys[key]
+		}
+		labels[i] = strings.Join(parts, "  ")
+		keyWidth = max(keyWidth, len(labels[i]))
+	}
+	keyWidth = max(keyWidth, len("TOTAL"))
+
+	fmt.Fprintf(w, "%-*s %8s %6s %12s %10s %12s %12s %9s %10s\n", keyWidth, strings.ToUpper(strings.Join(by, "  ")),
+		"REQS", "ERRS", "INPUT", "OUTPUT", "CACHE_READ", "CACHE_WRITE", "AVG_MS", "COST")
+	var total usage.Row
+	for i, row := range rows {
+		fmt.Fprintf(w, "%-*s %8d %6d %12d %10d %12d %12d %9d %10s\n", keyWidth, labels[i],
+			row.Requests, row.Errors, row.InputTokens, row.OutputTokens, row.CacheReadTokens, row.CacheWriteTokens, row.LatencyMs,
+			fmt.Sprintf("$%.2f", row.CostUSD))
+		total.Requests += row.Requests
+		total.Errors += row.Errors
+		total.CostUSD += row.CostUSD
+		total.Add(row.Usage)
+	}
+	if len(rows) > 1 {
+		fmt.Fprintf(w, "%-*s %8d %6d %12d %10d %12d %12d %9s %10s\n", keyWidth, "TOTAL",
+			total.Requests, total.Errors, total.InputTokens, total.OutputTokens, total.CacheReadTokens, total.CacheWriteTokens,
+			"", fmt.Sprintf("$%.2f", total.CostUSD))
+	}
+}
+
+func writeUsageCSV(out io.Writer, rows []usage.Row, by []string) {
+	w := csv.NewWriter(out)
+	header := append(append([]string{}, by...),
+		"requests", "errors", "input_tokens", "output_tokens", "cache_read_tokens", "cache_write_tokens", "avg_latency_ms", "cost_usd")
+	w.Write(header)
+	for _, row := range rows {
+		var record []string
+		for _, key := range by {
+			record = append(record, row.Keys[key])
+		}
+		for _, n := range []int64{int64(row.Requests), int64(row.Errors), int64(row.InputTokens), int64(row.OutputTokens),
+			int64(row.CacheReadTokens), int64(row.CacheWriteTokens), row.LatencyMs} {
+			record = append(record, strconv.FormatInt(n, 10))
+		}
+		record = append(record, strconv.FormatFloat(row.CostUSD, 'f', 6, 64))
+		w.Write(record)
+	}
+	w.Flush()
+}
+
+// parseSince accepts a relative age ("7d", "24h", "90m") or a date
+// ("2006-01-02").
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err == nil {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a duration (7d, 24h) or date (YYYY-MM-DD)", value)
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/schachte/claudecode-opencode-proxy/usage"
+)
+
+var update = flag.Bool("update", false, "rewrite the expected files in testdata")
+
+// checkGolden compares got with the file at path, or writes it with -update.
+func checkGolden(t *testing.T, path string, got []byte) {
+	t.Helper()
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s mismatch\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func TestWriteUsage(t *testing.T) {
+	// Days are taken in local time.
+	local := time.Local
+	time.Local = time.UTC
+	t.Cleanup(func() { time.Local = local })
+
+	now := time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		since  string
+		by     []string
+		format string
+	}{
+		{"default.txt", "", nil, "text"},
+		{"by_upstream_model.txt", "", []string{"upstream_model"}, "text"},
+		{"report.json", "", nil, "json"},
+		{"report.csv", "", nil, "csv"},
+		{"since.csv", "24h", []string{"model"}, "csv"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var since time.Time
+			if tt.since != "" {
+				var err error
+				if since, err = parseSince(tt.since, now); err != nil {
+					t.Fatal(err)
+				}
+			}
+			records, err := usage.ReadLedger(filepath.Join("testdata", "usage", "ledger.jsonl"), since)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			if err := writeUsage(&out, records, usage.Prices(nil), tt.by, tt.format); err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, filepath.Join("testdata", "usage", tt.name), out.Bytes())
+		})
+	}
+}
+
+func TestWriteUsageUnknownGroup(t *testing.T) {
+	var out bytes.Buffer
+	if err := writeUsage(&out, nil, usage.Prices(nil), []string{"colour"}, "json"); err == nil {
+		t.Error("expected an error for an unknown group")
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2025, 6, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"7d", now.AddDate(0, 0, -7)},
+		{"90m", now.Add(-90 * time.Minute)},
+		{"2025-06-01", time.Date(2025, 6, 1, 0, 0, 0, 0, time.Local)},
+		{"2025-06-01T08:00:00Z", time.Date(2025, 6, 1, 8, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := parseSince(tt.value, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseSince(%q) = %v, %v; want %v", tt.value, got, err, tt.want)
+		}
+	}
+	if _, err := parseSince("last week", now); err == nil {
+		t.Error("expected an error")
+	}
+}
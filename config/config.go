@@ -5,11 +5,17 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
+
+	"github.com/schachte/claudecode-opencode-proxy/bedrock"
+	"github.com/schachte/claudecode-opencode-proxy/usage"
+	"github.com/schachte/claudecode-opencode-proxy/vertex"
 )
 
 var (
@@ -18,19 +24,306 @@ var (
 	EnvFile    = filepath.Join(ConfigDir, "env")
 	LogFile    = filepath.Join(ConfigDir, "proxy.log")
 	PidFile    = filepath.Join(ConfigDir, "proxy.pid")
+	CrashFile  = filepath.Join(ConfigDir, "proxy.crash")
+	UsageFile  = filepath.Join(ConfigDir, "usage.jsonl")
+	CaptureDir = filepath.Join(ConfigDir, "captures")
 )
 
 type Config struct {
-	Target         string `json:"target"`
-	AuthType       string `json:"auth_type"`
-	APIKey         string `json:"api_key"`
-	LoginURL       string `json:"login_url"`
-	CfAccess       bool   `json:"cf_access"`
-	CfClientID     string `json:"cf_client_id"`
-	CfClientSecret string `json:"cf_client_secret"`
-	Proxy          string `json:"proxy,omitempty"`
-	CACert         string `json:"ca_cert,omitempty"`
-	InsecureSkip   bool   `json:"insecure_skip_verify,omitempty"`
+	Target         string         `json:"target"`
+	AuthType       string         `json:"auth_type"`
+	APIKey         string         `json:"api_key"`
+	LoginURL       string         `json:"login_url"`
+	CfAccess       bool           `json:"cf_access"`
+	CfClientID     string         `json:"cf_client_id"`
+	CfClientSecret string         `json:"cf_client_secret"`
+	Proxy          string         `json:"proxy,omitempty"`
+	CACert         string         `json:"ca_cert,omitempty"`
+	InsecureSkip   bool           `json:"insecure_skip_verify,omitempty"`
+	Protocol       string         `json:"protocol,omitempty"` // anthropic (default) or openai
+	AWS            *AWSConfig     `json:"aws,omitempty"`
+	Vertex         *VertexConfig  `json:"vertex,omitempty"`
+	Limits         *Limits        `json:"limits,omitempty"`
+	Retry          *RetryConfig   `json:"retry,omitempty"`
+	Breaker        *BreakerConfig `json:"circuit_breaker,omitempty"`
+	Timeouts       *TimeoutConfig `json:"timeouts,omitempty"`
+	Tracing        *TracingConfig `json:"tracing,omitempty"`
+	Log            *LogConfig     `json:"log,omitempty"`
+
+	Upstreams []Upstream `json:"upstreams,omitempty"`
+
+	// ForwardHeaders and DropHeaders select which client headers are passed
+	// upstream. Patterns are case-insensitive and may use '*' wildcards.
+	ForwardHeaders []string `json:"forward_headers"`
+	DropHeaders    []string `json:"drop_headers,omitempty"`
+
+	// Rules rewrite the JSON request body, applied in order.
+	Rules []RewriteRule `json:"rules"`
+
+	// Models maps client model names to upstream model names; keys may be
+	// globs. Routes pick the upstream tried first for matching models.
+	Models map[string]string `json:"models,omitempty"`
+	Routes []Route           `json:"routes,omitempty"`
+
+	// Prices override the built-in per-model prices (USD per million tokens).
+	Prices map[string]usage.Price `json:"prices,omitempty"`
+
+	// Budgets cap estimated spend per day or month. Soft caps log a warning;
+	// hard caps reject requests until the period resets.
+	Budgets []usage.Budget `json:"budgets,omitempty"`
+}
+
+// Route sends requests for models matching the Model glob to the named
+// upstream first. The remaining upstreams stay available for failover.
+type Route struct {
+	Model    string `json:"model"`
+	Upstream string `json:"upstream"`
+}
+
+// RewriteRule edits one field of the request body. Field is a dotted path
+// ("metadata.user_id", optionally written as "$.metadata.user_id").
+type RewriteRule struct {
+	Name   string      `json:"name,omitempty"`
+	Match  RuleMatch   `json:"match,omitempty"`
+	Action string      `json:"action"` // delete, set, rename
+	Field  string      `json:"field"`
+	To     string      `json:"to,omitempty"`    // rename destination
+	Value  interface{} `json:"value,omitempty"` // set value
+}
+
+// RuleMatch limits a rule to requests whose model, path and upstream name
+// match the given glob patterns. Empty patterns match everything.
+type RuleMatch struct {
+	Model    string `json:"model,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Upstream string `json:"upstream,omitempty"`
+}
+
+// DefaultRules strips fields that most gateways reject.
+func DefaultRules() []RewriteRule {
+	return []RewriteRule{
+		{Name: "strip-context-management", Action: "delete", Field: "context_management"},
+		{Name: "strip-mcp-servers", Action: "delete", Field: "mcp_servers"},
+	}
+}
+
+// AWSConfig holds Bedrock settings for auth_type "bedrock". Without static
+// keys, credentials come from the environment or the shared credentials file.
+type AWSConfig struct {
+	Region          string `json:"region,omitempty"`
+	Profile         string `json:"profile,omitempty"`
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+	SessionToken    string `json:"session_token,omitempty"`
+}
+
+// VertexConfig holds Vertex AI settings for auth_type "vertex". Credentials
+// is a service-account JSON key, defaulting to GOOGLE_APPLICATION_CREDENTIALS.
+type VertexConfig struct {
+	ProjectID   string `json:"project_id"`
+	Region      string `json:"region,omitempty"`
+	Credentials string `json:"credentials,omitempty"`
+	TokenURL    string `json:"token_url,omitempty"`
+}
+
+// Limits throttle requests to an upstream. Requests over a limit wait in a
+// queue for up to QueueTimeoutSeconds (default 60) before failing. Zero
+// values mean unlimited.
+type Limits struct {
+	RequestsPerMinute    int `json:"requests_per_minute,omitempty"`
+	InputTokensPerMinute int `json:"input_tokens_per_minute,omitempty"`
+	MaxConcurrent        int `json:"max_concurrent,omitempty"`
+	QueueTimeoutSeconds  int `json:"queue_timeout_seconds,omitempty"`
+}
+
+// RetryConfig controls retries of connection errors, 429, 529 and other 5xx
+// responses. MaxAttempts counts the first try and applies per upstream; 0 or
+// 1 disables retries and nil means the default. Retries only happen before
+// any bytes reach the client.
+type RetryConfig struct {
+	MaxAttempts      *int `json:"max_attempts,omitempty"`
+	InitialBackoffMs int  `json:"initial_backoff_ms,omitempty"`
+	MaxBackoffMs     int  `json:"max_backoff_ms,omitempty"`
+}
+
+// RetryPolicy returns the retry settings with defaults filled in.
+// MaxAttempts is always set and at least 1.
+func (cfg Config) RetryPolicy() RetryConfig {
+	attempts := 3
+	policy := RetryConfig{MaxAttempts: &attempts, InitialBackoffMs: 500, MaxBackoffMs: 10000}
+	if cfg.Retry != nil {
+		if cfg.Retry.MaxAttempts != nil {
+			attempts = max(*cfg.Retry.MaxAttempts, 1)
+		}
+		if cfg.Retry.InitialBackoffMs > 0 {
+			policy.InitialBackoffMs = cfg.Retry.InitialBackoffMs
+		}
+		if cfg.Retry.MaxBackoffMs > 0 {
+			policy.MaxBackoffMs = cfg.Retry.MaxBackoffMs
+		}
+	}
+	return policy
+}
+
+// BreakerConfig controls the per-upstream circuit breaker. After
+// FailureThreshold consecutive connection errors or 5xx responses the
+// upstream is skipped for CooldownSeconds, then a single probe is allowed.
+type BreakerConfig struct {
+	FailureThreshold int `json:"failure_threshold,omitempty"`
+	CooldownSeconds  int `json:"cooldown_seconds,omitempty"`
+}
+
+// BreakerPolicy returns the circuit breaker settings with defaults filled in.
+func (cfg Config) BreakerPolicy() BreakerConfig {
+	policy := BreakerConfig{FailureThreshold: 5, CooldownSeconds: 30}
+	if cfg.Breaker != nil {
+		if cfg.Breaker.FailureThreshold > 0 {
+			policy.FailureThreshold = cfg.Breaker.FailureThreshold
+		}
+		if cfg.Breaker.CooldownSeconds > 0 {
+			policy.CooldownSeconds = cfg.Breaker.CooldownSeconds
+		}
+	}
+	return policy
+}
+
+// TimeoutConfig bounds each phase of an upstream request, in seconds.
+// Response headers of non-streaming requests arrive only once the whole
+// reply is generated, so that limit is generous. Idle is the longest gap
+// allowed between lines of a stream. Total covers one attempt from sending
+// the request to the end of the response body; 0 means unlimited and nil
+// means the default.
+type TimeoutConfig struct {
+	ConnectSeconds        int  `json:"connect_seconds,omitempty"`
+	TLSHandshakeSeconds   int  `json:"tls_handshake_seconds,omitempty"`
+	ResponseHeaderSeconds int  `json:"response_header_seconds,omitempty"`
+	IdleSeconds           int  `json:"idle_seconds,omitempty"`
+	TotalSeconds          *int `json:"total_seconds,omitempty"`
+}
+
+// TimeoutPolicy returns the timeout settings with defaults filled in.
+// TotalSeconds is always set.
+func (cfg Config) TimeoutPolicy() TimeoutConfig {
+	total := 1800
+	policy := TimeoutConfig{
+		ConnectSeconds:        10,
+		TLSHandshakeSeconds:   10,
+		ResponseHeaderSeconds: 600,
+		IdleSeconds:           120,
+		TotalSeconds:          &total,
+	}
+	if t := cfg.Timeouts; t != nil {
+		if t.ConnectSeconds > 0 {
+			policy.ConnectSeconds = t.ConnectSeconds
+		}
+		if t.TLSHandshakeSeconds > 0 {
+			policy.TLSHandshakeSeconds = t.TLSHandshakeSeconds
+		}
+		if t.ResponseHeaderSeconds > 0 {
+			policy.ResponseHeaderSeconds = t.ResponseHeaderSeconds
+		}
+		if t.IdleSeconds > 0 {
+			policy.IdleSeconds = t.IdleSeconds
+		}
+		if t.TotalSeconds != nil {
+			total = *t.TotalSeconds
+		}
+	}
+	return policy
+}
+
+// TracingConfig exports request spans over OTLP/HTTP. Endpoint is the
+// collector's base URL (http://localhost:4318) or its /v1/traces URL.
+type TracingConfig struct {
+	Endpoint    string            `json:"endpoint"`
+	ServiceName string            `json:"service_name,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+// TracingEndpoint returns the configured collector endpoint, falling back to
+// the standard OTEL_EXPORTER_OTLP_* environment variables. Empty disables
+// tracing.
+func (cfg Config) TracingEndpoint() string {
+	if cfg.Tracing != nil && cfg.Tracing.Endpoint != "" {
+		return cfg.Tracing.Endpoint
+	}
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+}
+
+// TracingServiceName returns the service.name reported on spans.
+func (cfg Config) TracingServiceName() string {
+	if cfg.Tracing != nil && cfg.Tracing.ServiceName != "" {
+		return cfg.Tracing.ServiceName
+	}
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		return name
+	}
+	return "claude-opencode-proxy"
+}
+
+// TracingHeaders returns extra headers for the collector, such as auth.
+func (cfg Config) TracingHeaders() map[string]string {
+	if cfg.Tracing == nil {
+		return nil
+	}
+	return cfg.Tracing.Headers
+}
+
+// LogConfig controls rotation of the proxy's log file. A segment is rotated
+// once it exceeds MaxSizeMB or is older than MaxAgeHours; rotated segments
+// are gzipped and only the newest MaxFiles are kept.
+type LogConfig struct {
+	MaxSizeMB   int   `json:"max_size_mb,omitempty"`
+	MaxAgeHours int   `json:"max_age_hours,omitempty"`
+	MaxFiles    int   `json:"max_files,omitempty"`
+	Compress    *bool `json:"compress,omitempty"`
+}
+
+// LogPolicy returns the log rotation settings with defaults filled in.
+func (cfg Config) LogPolicy() LogConfig {
+	compress := true
+	policy := LogConfig{MaxSizeMB: 50, MaxAgeHours: 24, MaxFiles: 5, Compress: &compress}
+	if l := cfg.Log; l != nil {
+		if l.MaxSizeMB > 0 {
+			policy.MaxSizeMB = l.MaxSizeMB
+		}
+		if l.MaxAgeHours > 0 {
+			policy.MaxAgeHours = l.MaxAgeHours
+		}
+		if l.MaxFiles > 0 {
+			policy.MaxFiles = l.MaxFiles
+		}
+		if l.Compress != nil {
+			policy.Compress = l.Compress
+		}
+	}
+	return policy
+}
+
+// Upstream is one entry in the ordered failover list. Each upstream carries
+// its own target and auth settings; proxy and TLS settings are shared.
+type Upstream struct {
+	Name           string        `json:"name"`
+	Target         string        `json:"target"`
+	AuthType       string        `json:"auth_type"`
+	APIKey         string        `json:"api_key,omitempty"`
+	LoginURL       string        `json:"login_url,omitempty"`
+	CfAccess       bool          `json:"cf_access"`
+	CfClientID     string        `json:"cf_client_id,omitempty"`
+	CfClientSecret string        `json:"cf_client_secret,omitempty"`
+	Protocol       string        `json:"protocol,omitempty"`
+	AWS            *AWSConfig    `json:"aws,omitempty"`
+	Vertex         *VertexConfig `json:"vertex,omitempty"`
+
+	// Limits apply to this upstream only; when unset the top-level limits
+	// are used, with separate counters per upstream.
+	Limits *Limits `json:"limits,omitempty"`
+
+	// Models overrides the top-level model map for this upstream.
+	Models map[string]string `json:"models,omitempty"`
 }
 
 func DefaultConfig() Config {
@@ -40,6 +333,13 @@ func DefaultConfig() Config {
 		APIKey:   filepath.Join(os.Getenv("HOME"), ".local/share/opencode/auth.json"),
 		LoginURL: "https://opencode.custom.dev",
 		CfAccess: true,
+		ForwardHeaders: []string{
+			"anthropic-*",
+			"x-stainless-*",
+			"x-app",
+			"user-agent",
+		},
+		Rules: DefaultRules(),
 	}
 }
 
@@ -64,8 +364,86 @@ func SaveConfig(cfg Config) error {
 	return os.WriteFile(ConfigFile, data, 0644)
 }
 
+// ResolveUpstreams returns the upstreams in failover order. Without an explicit
+// list, the top-level target and auth settings form the only upstream.
+func (cfg Config) ResolveUpstreams() []Upstream {
+	if len(cfg.Upstreams) > 0 {
+		upstreams := make([]Upstream, len(cfg.Upstreams))
+		for i, u := range cfg.Upstreams {
+			if u.Name == "" {
+				u.Name = fmt.Sprintf("upstream-%d", i+1)
+			}
+			if u.Target == "" {
+				u.Target = defaultTarget(cfg.ForUpstream(u))
+			}
+			if u.Limits == nil {
+				u.Limits = cfg.Limits
+			}
+			upstreams[i] = u
+		}
+		return upstreams
+	}
+	return []Upstream{{
+		Name:           "default",
+		Target:         cfg.Target,
+		AuthType:       cfg.AuthType,
+		APIKey:         cfg.APIKey,
+		LoginURL:       cfg.LoginURL,
+		CfAccess:       cfg.CfAccess,
+		CfClientID:     cfg.CfClientID,
+		CfClientSecret: cfg.CfClientSecret,
+		Protocol:       cfg.Protocol,
+		AWS:            cfg.AWS,
+		Vertex:         cfg.Vertex,
+		Limits:         cfg.Limits,
+	}}
+}
+
+// defaultTarget derives the base URL for cloud upstreams that can be
+// configured by region alone.
+func defaultTarget(cfg Config) string {
+	switch cfg.AuthType {
+	case "bedrock":
+		return bedrock.Endpoint(AWSRegion(cfg))
+	case "vertex":
+		if cfg.Vertex != nil {
+			return vertex.Endpoint(cfg.Vertex.Region)
+		}
+	}
+	return ""
+}
+
+// ForUpstream returns a copy of cfg with the target and auth settings of u,
+// so helpers like GetToken can be used unchanged.
+func (cfg Config) ForUpstream(u Upstream) Config {
+	cfg.Target = u.Target
+	cfg.AuthType = u.AuthType
+	cfg.APIKey = u.APIKey
+	cfg.LoginURL = u.LoginURL
+	cfg.CfAccess = u.CfAccess
+	cfg.CfClientID = u.CfClientID
+	cfg.CfClientSecret = u.CfClientSecret
+	cfg.Protocol = u.Protocol
+	cfg.AWS = u.AWS
+	cfg.Vertex = u.Vertex
+	return cfg
+}
+
+// CreateHTTPClient builds the upstream client. The total timeout covers the
+// whole exchange including the body; the idle timeout between stream lines
+// is enforced by the proxy, which can tell the client why it stopped.
 func CreateHTTPClient(cfg Config) (*http.Client, error) {
-	transport := &http.Transport{}
+	timeouts := cfg.TimeoutPolicy()
+	transport := &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   seconds(timeouts.ConnectSeconds),
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   seconds(timeouts.TLSHandshakeSeconds),
+		ResponseHeaderTimeout: seconds(timeouts.ResponseHeaderSeconds),
+		IdleConnTimeout:       90 * time.Second,
+		ForceAttemptHTTP2:     true,
+	}
 
 	if cfg.Proxy != "" {
 		proxyURL, err := url.Parse(cfg.Proxy)
@@ -97,16 +475,37 @@ func CreateHTTPClient(cfg Config) (*http.Client, error) {
 		transport.TLSClientConfig = tlsConfig
 	}
 
-	return &http.Client{
-		Transport: transport,
-		Timeout:   5 * time.Minute,
-	}, nil
+	// No Client.Timeout: it would also cut off long streams. The proxy sets
+	// the total deadline on each request's context instead.
+	return &http.Client{Transport: transport}, nil
+}
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
 }
 
 func GetToken(cfg Config) (string, string, error) {
 	if cfg.AuthType == "apikey" {
 		return cfg.APIKey, "apikey", nil
 	}
+	if cfg.AuthType == "bedrock" {
+		creds, err := AWSCredentials(cfg)
+		if err != nil {
+			return "", "", err
+		}
+		return creds.AccessKeyID, "bedrock", nil
+	}
+	if cfg.AuthType == "vertex" {
+		ts, err := vertexTokenSource(cfg)
+		if err != nil {
+			return "", "", err
+		}
+		token, err := ts.Token()
+		if err != nil {
+			return "", "", err
+		}
+		return token, "vertex", nil
+	}
 
 	data, err := os.ReadFile(cfg.APIKey)
 	if err != nil {
@@ -128,7 +527,76 @@ func GetToken(cfg Config) (string, string, error) {
 	return auth.Token, "opencode", nil
 }
 
+// AWSCredentials resolves the credentials used to sign Bedrock requests.
+func AWSCredentials(cfg Config) (bedrock.Credentials, error) {
+	var static bedrock.Credentials
+	profile := ""
+	if cfg.AWS != nil {
+		static = bedrock.Credentials{
+			AccessKeyID:     cfg.AWS.AccessKeyID,
+			SecretAccessKey: cfg.AWS.SecretAccessKey,
+			SessionToken:    cfg.AWS.SessionToken,
+		}
+		profile = cfg.AWS.Profile
+	}
+	return bedrock.LoadCredentials(static, profile)
+}
+
+// AWSRegion returns the configured Bedrock region, falling back to the
+// AWS_REGION environment variable and then us-east-1.
+func AWSRegion(cfg Config) string {
+	if cfg.AWS != nil && cfg.AWS.Region != "" {
+		return cfg.AWS.Region
+	}
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return "us-east-1"
+}
+
+var (
+	vertexMu      sync.Mutex
+	vertexSources = make(map[string]*vertex.TokenSource)
+)
+
+// vertexTokenSource returns a shared token source per key file and token
+// endpoint, so access tokens are cached across requests.
+func vertexTokenSource(cfg Config) (*vertex.TokenSource, error) {
+	vc := VertexConfig{}
+	if cfg.Vertex != nil {
+		vc = *cfg.Vertex
+	}
+	if vc.Credentials == "" {
+		vc.Credentials = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if vc.Credentials == "" {
+		return nil, fmt.Errorf("no Vertex credentials: set vertex.credentials or GOOGLE_APPLICATION_CREDENTIALS")
+	}
+
+	vertexMu.Lock()
+	defer vertexMu.Unlock()
+	cacheKey := vc.Credentials + "|" + vc.TokenURL
+	if ts, ok := vertexSources[cacheKey]; ok {
+		return ts, nil
+	}
+	client, err := CreateHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ts, err := vertex.NewTokenSource(vc.Credentials, vc.TokenURL, client)
+	if err != nil {
+		return nil, err
+	}
+	vertexSources[cacheKey] = ts
+	return ts, nil
+}
+
 func APIKeyHelper(cfg Config) string {
+	if cfg.AuthType == "bedrock" || cfg.AuthType == "vertex" {
+		// The proxy authenticates upstream itself; Claude Code only needs a
+		// placeholder key.
+		return fmt.Sprintf("echo '%s'", cfg.AuthType)
+	}
 	if cfg.AuthType == "apikey" {
 		return fmt.Sprintf("echo '%s'", cfg.APIKey)
 	}
@@ -0,0 +1,297 @@
+// Package logfile writes the proxy log with built-in rotation. The live
+// segment is rotated by size and age to <path>.<timestamp>, old segments are
+// gzipped in the background, and only the newest few are kept.
+package logfile
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Policy says when to rotate and what to keep. Zero MaxBytes or MaxAge
+// disables that trigger; Keep below 1 keeps every segment.
+type Policy struct {
+	MaxBytes int64
+	MaxAge   time.Duration
+	Keep     int
+	Compress bool
+}
+
+// stampLayout names rotated segments so they sort oldest first.
+const stampLayout = "20060102-150405"
+
+// Writer is an io.Writer that appends to path and rotates it. It is safe
+// for concurrent use.
+type Writer struct {
+	path   string
+	policy Policy
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	started time.Time
+	closed  bool
+	pending sync.WaitGroup
+	// tidy serializes compression and pruning, so a prune never removes a
+	// segment another goroutine is still compressing.
+	tidy sync.Mutex
+}
+
+// Open appends to path, creating it and its directory if needed. An
+// existing file already past the age limit is rotated first.
+func Open(path string, policy Policy) (*Writer, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	w := &Writer{path: path, policy: policy}
+	if info, err := os.Stat(path); err == nil && info.Size() > 0 &&
+		policy.MaxAge > 0 && time.Since(info.ModTime()) > policy.MaxAge {
+		if err := w.archive(time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file, w.size, w.started = f, info.Size(), time.Now()
+	return nil
+}
+
+// Write appends p, rotating first if p would push the segment over the size
+// limit or the segment has outlived the age limit. Callers should write
+// whole lines so no line is split across segments.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+	if w.file == nil {
+		// A failed reopen after rotating; try again.
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+	now := time.Now()
+	if w.size > 0 && ((w.policy.MaxBytes > 0 && w.size+int64(len(p)) > w.policy.MaxBytes) ||
+		(w.policy.MaxAge > 0 && now.Sub(w.started) > w.policy.MaxAge)) {
+		if err := w.rotate(now); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Close closes the live segment and waits for background compression.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	var err error
+	w.closed = true
+	if w.file != nil {
+		err = w.file.Close()
+		w.file = nil
+	}
+	w.mu.Unlock()
+	w.pending.Wait()
+	return err
+}
+
+// rotate starts a new segment. When the old one cannot be archived, the
+// live file is reopened and kept, and rotation is tried again once another
+// segment's worth has been written, so logging never stops.
+func (w *Writer) rotate(now time.Time) error {
+	w.file.Close()
+	w.file = nil
+	archiveErr := w.archive(now)
+	if err := w.open(); err != nil {
+		return err
+	}
+	if archiveErr != nil {
+		w.size = 0
+		fmt.Fprintf(os.Stderr, "Warning: failed to rotate %s: %v\n", w.path, archiveErr)
+	}
+	return nil
+}
+
+// archive renames the live file to a timestamped segment, then compresses
+// and prunes in the background.
+func (w *Writer) archive(now time.Time) error {
+	name := w.path + "." + now.Format(stampLayout)
+	for i := 1; exists(name) || exists(name+".gz"); i++ {
+		name = fmt.Sprintf("%s.%s-%d", w.path, now.Format(stampLayout), i)
+	}
+	if err := os.Rename(w.path, name); err != nil {
+		return err
+	}
+	w.pending.Add(1)
+	go func() {
+		defer w.pending.Done()
+		w.tidy.Lock()
+		defer w.tidy.Unlock()
+		if w.policy.Compress {
+			if err := compress(name); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to compress %s: %v\n", name, err)
+			}
+		}
+		w.prune()
+	}()
+	return nil
+}
+
+// prune removes the oldest segments beyond the retention count.
+func (w *Writer) prune() {
+	if w.policy.Keep < 1 {
+		return
+	}
+	segments, err := Segments(w.path)
+	if err != nil {
+		return
+	}
+	for len(segments) > w.policy.Keep {
+		os.Remove(segments[0])
+		segments = segments[1:]
+	}
+}
+
+func compress(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp := name + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, name+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// Segments lists the rotated segments of path, oldest first, not including
+// the live file. Compressed segments end in .gz.
+func Segments(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var segments []string
+	for _, m := range matches {
+		if isSegment(strings.TrimSuffix(strings.TrimPrefix(m, path+"."), ".gz")) {
+			segments = append(segments, m)
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segmentKey(path, segments[i]) < segmentKey(path, segments[j])
+	})
+	return segments, nil
+}
+
+// SegmentTime returns when a segment of path was rotated out, which is
+// after its last line was written.
+func SegmentTime(path, name string) (time.Time, bool) {
+	stamp := strings.TrimSuffix(strings.TrimPrefix(name, path+"."), ".gz")
+	if !isSegment(stamp) {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(stampLayout, stamp[:len(stampLayout)], time.Local)
+	return t, err == nil
+}
+
+// isSegment reports whether stamp is a segment suffix: a timestamp with an
+// optional -N collision counter.
+func isSegment(stamp string) bool {
+	if len(stamp) < len(stampLayout) {
+		return false
+	}
+	if _, err := time.Parse(stampLayout, stamp[:len(stampLayout)]); err != nil {
+		return false
+	}
+	counter := stamp[len(stampLayout):]
+	if counter == "" {
+		return true
+	}
+	_, err := strconv.Atoi(strings.TrimPrefix(counter, "-"))
+	return strings.HasPrefix(counter, "-") && err == nil
+}
+
+// segmentKey orders segments by stamp and then collision counter, so
+// "x.20260101-000000" sorts before "x.20260101-000000-1" whether or not
+// either is compressed.
+func segmentKey(path, name string) string {
+	stamp := strings.TrimSuffix(strings.TrimPrefix(name, path+"."), ".gz")
+	n := 0
+	if len(stamp) > len(stampLayout) {
+		n, _ = strconv.Atoi(stamp[len(stampLayout)+1:])
+	}
+	return fmt.Sprintf("%s-%06d", stamp[:len(stampLayout)], n)
+}
+
+// OpenSegment returns a reader for a log segment, decompressing .gz segments.
+func OpenSegment(name string) (io.ReadCloser, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(name, ".gz") {
+		return f, nil
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &gzipFile{zr, f}, nil
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g *gzipFile) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
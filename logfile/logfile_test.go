@@ -0,0 +1,290 @@
+package logfile
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeLines(t *testing.T, w *Writer, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if _, err := w.Write([]byte(line + "\n")); err != nil {
+			t.Fatalf("Write(%q): %v", line, err)
+		}
+	}
+}
+
+func readSegment(t *testing.T, name string) string {
+	t.Helper()
+	r, err := OpenSegment(name)
+	if err != nil {
+		t.Fatalf("OpenSegment(%s): %v", name, err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	return string(data)
+}
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func touch(t *testing.T, name string, content string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRotateBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "proxy.log")
+	w, err := Open(path, Policy{MaxBytes: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each line is 10 bytes, so every third line starts a new segment.
+	writeLines(t, w, "line 0001", "line 0002", "line 0003", "line 0004", "line 0005")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	segments, err := Segments(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 2 {
+		t.Fatalf("segments = %v, want 2", segments)
+	}
+	// Both rotations ran within the same second or two; either way, names
+	// must be unique and in write order.
+	var got []string
+	for _, s := range segments {
+		got = append(got, readSegment(t, s))
+	}
+	got = append(got, readFile(t, path))
+	want := []string{"line 0001\nline 0002\n", "line 0003\nline 0004\n", "line 0005\n"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("contents = %q, want %q", got, want)
+	}
+}
+
+func TestRotateCollisionSuffix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.log")
+	w := &Writer{path: path}
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.Local)
+	touch(t, path+".20260102-030405.gz", "") // an older, compressed segment from the same second
+	for i := 0; i < 3; i++ {
+		touch(t, path, "x\n")
+		if err := w.archive(now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.pending.Wait()
+
+	segments, _ := Segments(path)
+	var names []string
+	for _, s := range segments {
+		names = append(names, filepath.Base(s))
+	}
+	want := []string{
+		"proxy.log.20260102-030405.gz",
+		"proxy.log.20260102-030405-1",
+		"proxy.log.20260102-030405-2",
+		"proxy.log.20260102-030405-3",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("segments = %v, want %v", names, want)
+	}
+}
+
+func TestRotateByAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.log")
+	w, err := Open(path, Policy{MaxAge: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLines(t, w, "old")
+	time.Sleep(100 * time.Millisecond)
+	writeLines(t, w, "new")
+	w.Close()
+
+	segments, _ := Segments(path)
+	if len(segments) != 1 || readSegment(t, segments[0]) != "old\n" {
+		t.Fatalf("segments = %v", segments)
+	}
+	if got := readFile(t, path); got != "new\n" {
+		t.Errorf("live file = %q", got)
+	}
+}
+
+func TestOpenRotatesStaleFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.log")
+	touch(t, path, "yesterday\n")
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Open(path, Policy{MaxAge: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLines(t, w, "today")
+	w.Close()
+
+	segments, _ := Segments(path)
+	if len(segments) != 1 || readSegment(t, segments[0]) != "yesterday\n" {
+		t.Fatalf("segments = %v", segments)
+	}
+	if got := readFile(t, path); got != "today\n" {
+		t.Errorf("live file = %q", got)
+	}
+}
+
+func TestSegmentsOrder(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "proxy.log")
+	for _, name := range []string{
+		"proxy.log",
+		"proxy.log.20260102-000000-10",
+		"proxy.log.20260102-000000-2.gz",
+		"proxy.log.20260102-000000",
+		"proxy.log.20260101-235959.gz",
+		"proxy.log.20260102-000000-1.gz",
+		"proxy.log.20260103-000000.gz.tmp", // compression in progress
+		"proxy.log.bak",
+		"proxy.log.20260102-000000-x",
+		"other.log.20260101-000000",
+	} {
+		touch(t, filepath.Join(dir, name), "")
+	}
+
+	segments, err := Segments(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, s := range segments {
+		names = append(names, filepath.Base(s))
+	}
+	want := []string{
+		"proxy.log.20260101-235959.gz",
+		"proxy.log.20260102-000000",
+		"proxy.log.20260102-000000-1.gz",
+		"proxy.log.20260102-000000-2.gz",
+		"proxy.log.20260102-000000-10",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Segments = %v, want %v", names, want)
+	}
+
+	at, ok := SegmentTime(path, segments[2])
+	if !ok || !at.Equal(time.Date(2026, 1, 2, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("SegmentTime = %v, %v", at, ok)
+	}
+	if _, ok := SegmentTime(path, filepath.Join(dir, "proxy.log.bak")); ok {
+		t.Error("SegmentTime accepted a non-segment")
+	}
+}
+
+func TestPruneAndCompress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.log")
+	w, err := Open(path, Policy{MaxBytes: 10, Keep: 2, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLines(t, w, "line 0001", "line 0002", "line 0003", "line 0004", "line 0005")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	segments, _ := Segments(path)
+	if len(segments) != 2 {
+		t.Fatalf("segments = %v, want the newest 2", segments)
+	}
+	for i, s := range segments {
+		if !strings.HasSuffix(s, ".gz") {
+			t.Errorf("segment %s is not compressed", s)
+		}
+		if want := []string{"line 0003\n", "line 0004\n"}[i]; readSegment(t, s) != want {
+			t.Errorf("segment %s = %q, want %q", s, readSegment(t, s), want)
+		}
+	}
+	if leftovers, _ := filepath.Glob(path + ".*.tmp"); len(leftovers) > 0 {
+		t.Errorf("temporary files left behind: %v", leftovers)
+	}
+	if got := readFile(t, path); got != "line 0005\n" {
+		t.Errorf("live file = %q", got)
+	}
+}
+
+func TestOpenSegment(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "proxy.log.20260101-000000")
+	touch(t, plain, "plain\n")
+
+	compressed := filepath.Join(dir, "proxy.log.20260101-000001.gz")
+	f, err := os.Create(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(f)
+	zw.Write([]byte("compressed\n"))
+	zw.Close()
+	f.Close()
+
+	if got := readSegment(t, plain); got != "plain\n" {
+		t.Errorf("plain = %q", got)
+	}
+	if got := readSegment(t, compressed); got != "compressed\n" {
+		t.Errorf("compressed = %q", got)
+	}
+
+	corrupt := filepath.Join(dir, "proxy.log.20260101-000002.gz")
+	touch(t, corrupt, "not gzip")
+	if _, err := OpenSegment(corrupt); err == nil {
+		t.Error("OpenSegment accepted a corrupt .gz")
+	}
+}
+
+func TestRotateFailureKeepsWriting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.log")
+	w, err := Open(path, Policy{MaxBytes: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	writeLines(t, w, "line 0001", "line 0002")
+
+	// The live file disappears, so the next rotation has nothing to rename.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	writeLines(t, w, "line 0003", "line 0004")
+	if got := readFile(t, path); got != "line 0003\nline 0004\n" {
+		t.Errorf("live file = %q", got)
+	}
+
+	// Once the reopened file fills up, rotation works again.
+	writeLines(t, w, "line 0005")
+	segments, _ := Segments(path)
+	if len(segments) != 1 || readSegment(t, segments[0]) != "line 0003\nline 0004\n" {
+		t.Errorf("segments = %v", segments)
+	}
+	if got := readFile(t, path); got != "line 0005\n" {
+		t.Errorf("live file = %q", got)
+	}
+}
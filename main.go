@@ -3,8 +3,10 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/schachte/claudecode-opencode-proxy/cmd"
+	"github.com/schachte/claudecode-opencode-proxy/proxy"
 )
 
 func main() {
@@ -33,9 +35,9 @@ func main() {
 	case "serve":
 		port := 8787
 		bindAddr := "127.0.0.1"
-		verbose := false
 		foreground := false
-		quiet := false
+		captureDir := ""
+		var logs proxy.LogOptions
 		for i := 0; i < len(args); i++ {
 			switch args[i] {
 			case "-p", "--port":
@@ -49,24 +51,49 @@ func main() {
 					i++
 				}
 			case "-v", "--verbose":
-				verbose = true
+				logs.Verbose = true
 			case "-f", "--foreground":
 				foreground = true
 			case "-q", "--quiet":
-				quiet = true
+				logs.Quiet = true
+			case "--log-format":
+				if i+1 < len(args) {
+					logs.Format = args[i+1]
+					i++
+				}
+			case "--log-file":
+				if i+1 < len(args) {
+					logs.File = args[i+1]
+					i++
+				}
+			case "--capture":
+				if i+1 < len(args) {
+					dir, err := filepath.Abs(args[i+1])
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Invalid capture directory: %v\n", err)
+						os.Exit(1)
+					}
+					captureDir = dir
+					i++
+				}
+			case "--log-level":
+				if i+1 < len(args) {
+					logs.Level = args[i+1]
+					i++
+				}
 			}
 		}
 		if foreground {
-			cmd.ProxyForeground(port, bindAddr, verbose, quiet)
+			cmd.ProxyForeground(port, bindAddr, logs, captureDir)
 		} else {
-			cmd.ProxyBackground(port, bindAddr, verbose, quiet)
+			cmd.ProxyBackground(port, bindAddr, logs, captureDir)
 		}
 
 	case "stop", "kill":
 		cmd.ProxyStop()
 
 	case "logs", "tail":
-		cmd.ProxyLogs()
+		cmd.ProxyLogs(args)
 
 	case "enable":
 		cmd.Enable(parsePort())
@@ -89,6 +116,15 @@ func main() {
 	case "models":
 		cmd.Models(args)
 
+	case "usage":
+		cmd.Usage(args)
+
+	case "replay":
+		cmd.Replay(args)
+
+	case "mock-upstream":
+		cmd.MockUpstream(args)
+
 	case "-h", "--help", "help":
 		printUsage()
 
@@ -106,7 +142,7 @@ Commands:
   run        Launch claude with proxy status banner
   serve      Start the proxy server (background by default)
   stop       Stop the background proxy server (alias: kill)
-  logs       Tail the proxy logs (alias: tail)
+  logs       Show and follow the proxy logs (alias: tail)
   enable     Configure Claude Code to use proxy
   disable    Restore Claude Code to default auth
   login      Authenticate with OpenCode
@@ -114,6 +150,9 @@ Commands:
   config     View or modify proxy configuration
   env        Print environment variables
   models     List available models from connected source
+  usage      Summarize recorded token usage
+  replay     Resend a captured request and diff the response
+  mock-upstream  Serve a fake Anthropic API for offline testing
 
 Options for 'run':
   -o, --opencode          Use OpenCode proxy (skip prompt)
@@ -124,8 +163,38 @@ Options for 'serve':
   -p, --port <port>       Port to listen on (default: 8787)
   -b, --bind <addr>       Bind address (default: 127.0.0.1, use 0.0.0.0 for Docker)
   -f, --foreground        Run in foreground (default: background)
-  -v, --verbose           Enable verbose logging
+  -v, --verbose           Enable verbose logging (same as --log-level debug)
   -q, --quiet             Suppress all log output
+  --log-format <f>        Log format: text (default) or json (one record per event)
+  --log-level <l>         Minimum level: debug, info (default), warn, error
+  --log-file <path>       Write logs to a rotated file (background mode uses proxy.log)
+  --capture <dir>         Save each request, upstream request and response to dir
+                          (credentials redacted; prompts are kept)
+
+Options for 'logs' (no options: last 10 lines, then follow):
+  -n, --lines <n>         Show the last n matching lines (default: all when filtering)
+  -f, --follow            Keep printing new lines as they are logged
+  -s, --since <when>      Only lines since a duration (30m, 24h, 7d) or date
+  -m, --model <glob>      Only lines for matching models
+  --status <code>         Only lines with this status (e.g. 529, 5xx)
+  -e, --errors-only       Only warnings, errors and 4xx/5xx statuses
+  -r, --request <id>      Only lines of one request (proxy or upstream request-id)
+
+Options for 'replay <request-id|file>':
+  -d, --dir <dir>         Capture directory (default: ~/.config/claude-opencode-proxy/captures)
+  -t, --target <url>      Send to this base URL (default: http://127.0.0.1:8787)
+  -u, --upstream          Send the rewritten upstream request instead of the client's
+  -H, --header <h>        Add a header, e.g. "x-api-key: sk-..." (repeatable)
+
+Options for 'mock-upstream':
+  -p, --port <port>       Port to listen on (default: 9999)
+  -b, --bind <addr>       Bind address (default: 127.0.0.1)
+  --fixtures <dir>        Scripted replies: fixture or capture .json files
+  --text <text>           Reply text when no fixture matches (default: echo)
+  --chunk-delay <d>       Pause between stream events (e.g. 50ms)
+  --fault <spec>          Inject a fault (repeatable), e.g. status=529,first=2
+                          status=429,retry_after=1  delay=5s  disconnect=3
+                          rate=0.5  model=claude-opus-*
 
 Options for 'enable', 'env':
   -p, --port <port>       Port for ANTHROPIC_BASE_URL (default: 8787)
@@ -138,11 +207,23 @@ Options for 'models':
   -s, --source <url>      Query specific source (default: configured target)
                           Use "anthropic" for direct Anthropic API
 
+Options for 'usage':
+  -s, --since <when>      Only include requests since a duration (7d, 24h) or date
+  -b, --by <keys>         Group by day, model, upstream_model, project, upstream
+  -j, --json              Output as JSON
+  --csv                   Output as CSV
+
 Options for 'config':
   --target <url>          Upstream API URL
-  --auth-type <type>      Auth type: opencode, apikey
+  --auth-type <type>      Auth type: opencode, apikey, bedrock, vertex
   --auth-file <path>      Path to auth file or API key
   --auth-key <key>        Key in auth JSON file
+  --aws-region <region>   AWS region for Bedrock (default: $AWS_REGION)
+  --aws-profile <name>    Profile in the AWS shared credentials file
+  --vertex-project <id>   Google Cloud project for Vertex AI
+  --vertex-region <r>     Vertex AI region (e.g. us-east5, global)
+  --vertex-credentials <path>  Service account JSON key for Vertex AI
+  --protocol <p>          Upstream protocol: anthropic (default), openai
   --cf-access             Enable Cloudflare Access headers
   --no-cf-access          Disable Cloudflare Access headers
   --cf-client-id <id>     CF Access service token client ID
@@ -151,7 +232,43 @@ Options for 'config':
   --ca-cert <path>        Path to custom CA certificate (PEM format)
   --insecure-skip-verify  Skip TLS certificate verification (not recommended)
   --no-insecure-skip-verify  Enable TLS certificate verification
+  --add-upstream <n>=<url>   Append a failover upstream (inherits current auth)
+  --remove-upstream <name>   Remove a failover upstream
+  --model-map <from>=<to> Rewrite a model name (glob keys; empty <to> removes)
+  --route <glob>=<name>   Send matching models to an upstream first
+  --price <model>=<in>,<out>[,<cache_read>,<cache_write>]
+                          Override a model price (USD per million tokens)
+  --limits [<upstream>:]rpm=N,input_tpm=N,concurrent=N,queue_timeout=S
+                          Throttle an upstream (or all, without a name)
+  --retry <attempts>[,<initial_ms>,<max_ms>]
+                          Retry 429/5xx and connection errors; attempts
+                          counts the first try (0 or 1 disables)
+  --timeouts connect=S,tls=S,header=S,idle=S,total=S
+                          Upstream timeouts in seconds (idle = gap in a
+                          stream, total=0 = no overall limit)
+  --circuit-breaker <failures>[,<cooldown_s>]
+                          Skip an upstream after consecutive failures
+  --log-rotate size=MB,age=H,keep=N,compress=on|off
+                          Rotate the proxy log (default: 50MB or 24h, keep 5, gzip)
+  --otlp-endpoint <url>   Export traces over OTLP/HTTP ("none" disables)
+  --budget <period>[:<model>]=<hard>[,<soft>]
+                          Cap spend (USD) per daily/monthly period
+  --remove-budget <period>[:<model>]
+                          Remove a budget
+  --forward-header <pat>  Forward matching client headers (e.g. anthropic-*)
+  --drop-header <pat>     Never forward matching client headers
   --reset                 Reset to defaults
+
+Subcommands for 'config rules' (request body rewrite rules):
+  list                    List rules in the order they are applied
+  test [file] [--path p] [--upstream name]
+                          Apply rules to a sample body (stdin if no file)
+  add --action <a> --field <f> [--to <f>] [--value <json>]
+      [--model <glob>] [--path <glob>] [--upstream <glob>] [--name <n>]
+                          Append a rule (actions: delete, set, rename;
+                          set requires --value)
+  remove <number>         Remove a rule
+  reset                   Restore the default rules
 `
 	fmt.Print(usage)
 }
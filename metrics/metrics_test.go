@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"math"
+	"net/http/httptest"
+	"testing"
+)
+
+func scrape(t *testing.T, reg *Registry) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	reg.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	return rec.Body.String()
+}
+
+func TestExposition(t *testing.T) {
+	reg := NewRegistry()
+	requests := reg.Counter("requests_total", "Requests by model.\nSecond line.", "model", "status")
+	active := reg.Gauge("active", "Active streams.")
+	reg.GaugeFunc("circuit", "Circuit state.", []string{"upstream"}, func(emit func(float64, ...string)) {
+		emit(1, "a")
+		emit(0, "b")
+	})
+	latency := reg.Histogram("latency_seconds", "Latency.", []float64{0.5, 1, 2.5}, "model")
+
+	requests.Inc("sonnet", "200")
+	requests.Add(2, "sonnet", "200")
+	requests.Add(-5, "sonnet", "200") // counters never go down
+	requests.Inc(`we"ird\model`, "500")
+	active.Set(3)
+	active.Add(-1)
+	for _, v := range []float64{0.5, 0.7, 1, 3, 0.1} {
+		latency.Observe(v, "sonnet")
+	}
+	latency.Observe(math.Inf(1), "opus")
+
+	want := `# HELP requests_total Requests by model.\nSecond line.
+# TYPE requests_total counter
+requests_total{model="sonnet",status="200"} 3
+requests_total{model="we\"ird\\model",status="500"} 1
+# HELP active Active streams.
+# TYPE active gauge
+active 2
+# HELP circuit Circuit state.
+# TYPE circuit gauge
+circuit{upstream="a"} 1
+circuit{upstream="b"} 0
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{model="opus",le="0.5"} 0
+latency_seconds_bucket{model="opus",le="1"} 0
+latency_seconds_bucket{model="opus",le="2.5"} 0
+latency_seconds_bucket{model="opus",le="+Inf"} 1
+latency_seconds_sum{model="opus"} +Inf
+latency_seconds_count{model="opus"} 1
+latency_seconds_bucket{model="sonnet",le="0.5"} 2
+latency_seconds_bucket{model="sonnet",le="1"} 4
+latency_seconds_bucket{model="sonnet",le="2.5"} 4
+latency_seconds_bucket{model="sonnet",le="+Inf"} 5
+latency_seconds_sum{model="sonnet"} 5.3
+latency_seconds_count{model="sonnet"} 5
+`
+	if got := scrape(t, reg); got != want {
+		t.Errorf("exposition mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLabelCountMismatchPanics(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.Counter("c", "help", "a", "b")
+	h := reg.Histogram("h", "help", DefaultBuckets, "a")
+	for name, f := range map[string]func(){
+		"counter":   func() { c.Inc("only-one") },
+		"histogram": func() { h.Observe(1, "x", "y") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: no panic for the wrong number of labels", name)
+				}
+			}()
+			f()
+		}()
+	}
+}
@@ -0,0 +1,112 @@
+package mockupstream
+
+import (
+	"fmt"
+	"math/rand"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fault injects a failure into matching requests. Status answers with that
+// error status (429, 529, 500, ...) instead of a reply; Delay holds back the
+// first byte; DisconnectAfter drops the connection after that many stream
+// events. A fault applies to requests for Model (a glob; empty matches all),
+// only to the first First of them when First is set, and at random with
+// probability Rate when Rate is set.
+type Fault struct {
+	Model           string
+	Status          int
+	RetryAfter      int // seconds, sent with Status
+	Delay           time.Duration
+	DisconnectAfter int
+	First           int
+	Rate            float64
+
+	seen int
+}
+
+// ParseFault parses a comma-separated fault spec such as
+// "status=529,first=2", "status=429,retry_after=1,rate=0.5", "delay=5s" or
+// "disconnect=3,model=claude-opus-*".
+func ParseFault(spec string) (*Fault, error) {
+	f := &Fault{}
+	for _, part := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("expected key=value, got %q", part)
+		}
+		var err error
+		switch key {
+		case "status":
+			f.Status, err = strconv.Atoi(value)
+			if err == nil && (f.Status < 400 || f.Status > 599) {
+				err = fmt.Errorf("not an error status")
+			}
+		case "retry_after":
+			f.RetryAfter, err = strconv.Atoi(value)
+		case "delay":
+			f.Delay, err = time.ParseDuration(value)
+		case "disconnect":
+			f.DisconnectAfter, err = strconv.Atoi(value)
+		case "first":
+			f.First, err = strconv.Atoi(value)
+		case "rate":
+			f.Rate, err = strconv.ParseFloat(value, 64)
+			if err == nil && (f.Rate <= 0 || f.Rate > 1) {
+				err = fmt.Errorf("must be in (0, 1]")
+			}
+		case "model":
+			_, err = path.Match(value, "")
+			f.Model = value
+		default:
+			return nil, fmt.Errorf("unknown fault setting %q (use status, retry_after, delay, disconnect, first, rate, model)", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %v", key, value, err)
+		}
+	}
+	if f.Status == 0 && f.Delay == 0 && f.DisconnectAfter == 0 {
+		return nil, fmt.Errorf("fault needs status, delay or disconnect")
+	}
+	return f, nil
+}
+
+// plan is what the faults do to one request.
+type plan struct {
+	status          int
+	retryAfter      int
+	delay           time.Duration
+	disconnectAfter int
+}
+
+func (s *Server) plan(model string) plan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var p plan
+	for _, f := range s.Faults {
+		if f.Model != "" {
+			if ok, _ := path.Match(f.Model, model); !ok {
+				continue
+			}
+		}
+		f.seen++
+		if f.First > 0 && f.seen > f.First {
+			continue
+		}
+		if f.Rate > 0 && rand.Float64() >= f.Rate {
+			continue
+		}
+		if f.Status != 0 && p.status == 0 {
+			p.status, p.retryAfter = f.Status, f.RetryAfter
+		}
+		if f.Delay > p.delay {
+			p.delay = f.Delay
+		}
+		if f.DisconnectAfter > 0 && (p.disconnectAfter == 0 || f.DisconnectAfter < p.disconnectAfter) {
+			p.disconnectAfter = f.DisconnectAfter
+		}
+	}
+	return p
+}
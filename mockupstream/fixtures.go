@@ -0,0 +1,93 @@
+package mockupstream
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/schachte/claudecode-opencode-proxy/capture"
+)
+
+// Fixture is a scripted reply. Model (a glob) and Contains (a substring of
+// the raw request body) select requests; empty fields match everything.
+// The reply is either Text, rendered as JSON or SSE to suit the request, or
+// a recorded Response sent back verbatim.
+type Fixture struct {
+	Name     string    `json:"name,omitempty"`
+	Model    string    `json:"model,omitempty"`
+	Contains string    `json:"contains,omitempty"`
+	Text     string    `json:"text,omitempty"`
+	Response *Response `json:"response,omitempty"`
+}
+
+// Response is a recorded reply, in the capture file format.
+type Response = capture.Message
+
+func (s *Server) match(model string, body []byte) (Fixture, bool) {
+	for _, f := range s.Fixtures {
+		if f.Model != "" {
+			if ok, _ := path.Match(f.Model, model); !ok {
+				continue
+			}
+		}
+		if f.Contains != "" && !bytes.Contains(body, []byte(f.Contains)) {
+			continue
+		}
+		return f, true
+	}
+	return Fixture{}, false
+}
+
+// LoadFixtures reads every .json file in dir, in name order. A file holds a
+// Fixture, or an exchange saved by `serve --capture`, which replays its
+// response for requests to the same model.
+func LoadFixtures(dir string) ([]Fixture, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var fixtures []Fixture
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		f, err := parseFixture(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if f.Name == "" {
+			f.Name = strings.TrimSuffix(filepath.Base(name), ".json")
+		}
+		fixtures = append(fixtures, f)
+	}
+	return fixtures, nil
+}
+
+func parseFixture(data []byte) (Fixture, error) {
+	var probe struct {
+		Request json.RawMessage `json:"request"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return Fixture{}, err
+	}
+	if probe.Request != nil {
+		var exchange capture.Exchange
+		if err := json.Unmarshal(data, &exchange); err != nil {
+			return Fixture{}, err
+		}
+		return Fixture{Model: exchange.Model, Response: &exchange.Response}, nil
+	}
+	var f Fixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		return Fixture{}, err
+	}
+	if f.Text == "" && f.Response == nil {
+		return Fixture{}, fmt.Errorf("fixture needs text or response")
+	}
+	return f, nil
+}
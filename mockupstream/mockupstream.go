@@ -0,0 +1,360 @@
+// Package mockupstream is a fake Anthropic Messages API for testing the
+// proxy without network access. It answers /v1/messages, sync or streamed,
+// from scripted fixtures or by echoing the last user message, and can inject
+// faults: error statuses such as 429 and 529, a slow first byte, and a
+// disconnect partway through a stream.
+package mockupstream
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/schachte/claudecode-opencode-proxy/anthropic"
+)
+
+// DefaultModel is reported when a request names no model.
+const DefaultModel = "claude-mock"
+
+// Server is an http.Handler speaking the Anthropic Messages API. The zero
+// value echoes every request. Configure it before serving; it is safe for
+// concurrent requests.
+type Server struct {
+	// Fixtures are tried in order; the first match answers the request.
+	Fixtures []Fixture
+	// Faults are checked on every /v1/messages request.
+	Faults []*Fault
+	// Text, when set, is the reply to requests no fixture matches, instead
+	// of echoing the last user message.
+	Text string
+	// ChunkDelay is the pause between stream events.
+	ChunkDelay time.Duration
+	// Log, when set, is called once per request with a one-line summary.
+	Log func(format string, args ...interface{})
+
+	mu       sync.Mutex
+	requests int
+}
+
+// Requests returns how many /v1/messages requests have been served.
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("request-id", "req_mock_"+randomHex(12))
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/messages":
+		s.handleMessages(w, r)
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/messages/count_tokens":
+		body, _ := io.ReadAll(r.Body)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"input_tokens": estimateTokens(len(body))})
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/models":
+		s.handleModels(w)
+	default:
+		writeError(w, http.StatusNotFound, "Unknown endpoint: "+r.Method+" "+r.URL.Path)
+	}
+}
+
+// request is the part of a Messages request the mock looks at.
+type request struct {
+	Model    string `json:"model"`
+	Stream   bool   `json:"stream"`
+	Messages []struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	} `json:"messages"`
+}
+
+func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests++
+	n := s.requests
+	s.mu.Unlock()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+	var req request
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "Request body is not valid JSON: "+err.Error())
+		return
+	}
+	if req.Model == "" {
+		req.Model = DefaultModel
+	}
+	// A disconnect fault panics out of the write, leaving outcome as is.
+	outcome := "disconnected (fault)"
+	defer func() {
+		if s.Log != nil {
+			s.Log("#%d model=%s stream=%v -> %s", n, req.Model, req.Stream, outcome)
+		}
+	}()
+
+	plan := s.plan(req.Model)
+	if plan.delay > 0 {
+		select {
+		case <-time.After(plan.delay):
+		case <-r.Context().Done():
+			outcome = "client gone during delay"
+			return
+		}
+	}
+	if plan.status != 0 {
+		if plan.retryAfter > 0 {
+			w.Header().Set("retry-after", strconv.Itoa(plan.retryAfter))
+		}
+		writeError(w, plan.status, faultMessage(plan.status))
+		outcome = strconv.Itoa(plan.status) + " (fault)"
+		return
+	}
+
+	fixture, ok := s.match(req.Model, body)
+	switch {
+	case ok && fixture.Response != nil:
+		s.writeRecorded(w, r, fixture.Response, plan.disconnectAfter)
+		outcome = strconv.Itoa(statusOr200(fixture.Response.Status)) + " (" + fixture.Name + ")"
+	case ok:
+		s.writeText(w, r, req, body, fixture.Text, plan.disconnectAfter)
+		outcome = "200 (" + fixture.Name + ")"
+	default:
+		text := s.Text
+		if text == "" {
+			text = lastUserText(req)
+		}
+		s.writeText(w, r, req, body, text, plan.disconnectAfter)
+		outcome = "200"
+	}
+}
+
+func (s *Server) handleModels(w http.ResponseWriter) {
+	seen := map[string]bool{}
+	var models []map[string]interface{}
+	add := func(id string) {
+		if id != "" && !seen[id] && !strings.ContainsAny(id, "*?[") {
+			seen[id] = true
+			models = append(models, map[string]interface{}{"type": "model", "id": id, "display_name": id})
+		}
+	}
+	add(DefaultModel)
+	for _, f := range s.Fixtures {
+		add(f.Model)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": models, "has_more": false})
+}
+
+// writeText answers with a generated message holding text, as JSON or as
+// an SSE stream depending on the request.
+func (s *Server) writeText(w http.ResponseWriter, r *http.Request, req request, body []byte, text string, disconnectAfter int) {
+	id := "msg_mock_" + randomHex(12)
+	inputTokens := estimateTokens(len(body))
+	outputTokens := estimateTokens(len(text))
+	if !req.Stream {
+		if disconnectAfter > 0 {
+			// A sync response has no events to cut between; drop the
+			// connection before the body.
+			panic(http.ErrAbortHandler)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"id":            id,
+			"type":          "message",
+			"role":          "assistant",
+			"model":         req.Model,
+			"content":       []map[string]interface{}{{"type": "text", "text": text}},
+			"stop_reason":   "end_turn",
+			"stop_sequence": nil,
+			"usage":         map[string]interface{}{"input_tokens": inputTokens, "output_tokens": outputTokens},
+		})
+		return
+	}
+
+	events := [][]byte{
+		anthropic.Event("message_start", map[string]interface{}{
+			"type": "message_start",
+			"message": map[string]interface{}{
+				"id": id, "type": "message", "role": "assistant", "model": req.Model,
+				"content": []interface{}{}, "stop_reason": nil, "stop_sequence": nil,
+				"usage": map[string]interface{}{"input_tokens": inputTokens, "output_tokens": 1},
+			},
+		}),
+		anthropic.Event("content_block_start", map[string]interface{}{
+			"type": "content_block_start", "index": 0,
+			"content_block": map[string]interface{}{"type": "text", "text": ""},
+		}),
+		anthropic.Event("ping", map[string]interface{}{"type": "ping"}),
+	}
+	for _, chunk := range chunks(text) {
+		events = append(events, anthropic.Event("content_block_delta", map[string]interface{}{
+			"type": "content_block_delta", "index": 0,
+			"delta": map[string]interface{}{"type": "text_delta", "text": chunk},
+		}))
+	}
+	events = append(events,
+		anthropic.Event("content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": 0}),
+		anthropic.Event("message_delta", map[string]interface{}{
+			"type":  "message_delta",
+			"delta": map[string]interface{}{"stop_reason": "end_turn", "stop_sequence": nil},
+			"usage": map[string]interface{}{"output_tokens": outputTokens},
+		}),
+		anthropic.Event("message_stop", map[string]interface{}{"type": "message_stop"}),
+	)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	s.stream(w, r, events, disconnectAfter)
+}
+
+// writeRecorded replays a fixture's response verbatim. SSE bodies are sent
+// event by event so delays and disconnects apply.
+func (s *Server) writeRecorded(w http.ResponseWriter, r *http.Request, resp *Response, disconnectAfter int) {
+	for name, values := range resp.Header {
+		switch strings.ToLower(name) {
+		case "content-length", "request-id", "date":
+			continue
+		}
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	status := statusOr200(resp.Status)
+	body := []byte(resp.Body)
+	if !strings.HasPrefix(strings.TrimSpace(string(body)), "event:") {
+		if disconnectAfter > 0 {
+			panic(http.ErrAbortHandler)
+		}
+		w.WriteHeader(status)
+		w.Write(body)
+		return
+	}
+	var events [][]byte
+	for _, event := range strings.SplitAfter(string(body), "\n\n") {
+		if strings.TrimSpace(event) != "" {
+			events = append(events, []byte(event))
+		}
+	}
+	w.WriteHeader(status)
+	s.stream(w, r, events, disconnectAfter)
+}
+
+// stream writes events with ChunkDelay between them. After disconnectAfter
+// events (if set) it drops the connection without finishing the stream.
+func (s *Server) stream(w http.ResponseWriter, r *http.Request, events [][]byte, disconnectAfter int) {
+	flusher, _ := w.(http.Flusher)
+	for i, event := range events {
+		if disconnectAfter > 0 && i == disconnectAfter {
+			panic(http.ErrAbortHandler)
+		}
+		if i > 0 && s.ChunkDelay > 0 {
+			select {
+			case <-time.After(s.ChunkDelay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if _, err := w.Write(event); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// chunks splits text into word-sized stream deltas.
+func chunks(text string) []string {
+	var out []string
+	for len(text) > 0 {
+		i := strings.IndexByte(text[1:], ' ')
+		if i < 0 {
+			out = append(out, text)
+			break
+		}
+		out = append(out, text[:i+1])
+		text = text[i+1:]
+	}
+	return out
+}
+
+// lastUserText returns the text of the last user message.
+func lastUserText(req request) string {
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		msg := req.Messages[i]
+		if msg.Role != "user" {
+			continue
+		}
+		var s string
+		if json.Unmarshal(msg.Content, &s) == nil {
+			return s
+		}
+		var blocks []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		}
+		if json.Unmarshal(msg.Content, &blocks) == nil {
+			var parts []string
+			for _, b := range blocks {
+				if b.Type == "text" {
+					parts = append(parts, b.Text)
+				}
+			}
+			if len(parts) > 0 {
+				return strings.Join(parts, "\n")
+			}
+		}
+	}
+	return "Hello from the mock upstream."
+}
+
+// estimateTokens approximates a token count as bytes / 4, at least 1.
+func estimateTokens(n int) int {
+	if n < 4 {
+		return 1
+	}
+	return n / 4
+}
+
+// faultMessage mirrors the wording of the real API's error messages.
+func faultMessage(status int) string {
+	switch status {
+	case http.StatusTooManyRequests:
+		return "Number of request tokens has exceeded your per-minute rate limit (injected fault)"
+	case 529:
+		return "Overloaded (injected fault)"
+	}
+	return http.StatusText(status) + " (injected fault)"
+}
+
+func statusOr200(status int) int {
+	if status == 0 {
+		return http.StatusOK
+	}
+	return status
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(anthropic.ErrorBody(anthropic.ErrorType(status), message))
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,269 @@
+// Package openai translates between the Anthropic Messages API and the OpenAI
+// Chat Completions API, so upstreams such as vLLM or LiteLLM can be used by
+// clients that only speak Anthropic.
+package openai
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ChatCompletionsPath replaces /v1/messages on OpenAI-compatible upstreams.
+const ChatCompletionsPath = "/v1/chat/completions"
+
+// TranslateRequest converts an Anthropic Messages request body into a Chat
+// Completions request body.
+func TranslateRequest(req map[string]interface{}) ([]byte, error) {
+	out := map[string]interface{}{
+		"model": req["model"],
+	}
+
+	var messages []interface{}
+	if system := systemText(req["system"]); system != "" {
+		messages = append(messages, map[string]interface{}{"role": "system", "content": system})
+	}
+
+	rawMessages, _ := req["messages"].([]interface{})
+	for i, raw := range rawMessages {
+		msg, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("messages[%d] is not an object", i)
+		}
+		role, _ := msg["role"].(string)
+		switch role {
+		case "user":
+			messages = append(messages, userMessages(msg["content"])...)
+		case "assistant":
+			messages = append(messages, assistantMessage(msg["content"]))
+		default:
+			return nil, fmt.Errorf("messages[%d] has unsupported role %q", i, role)
+		}
+	}
+	out["messages"] = messages
+
+	if v, ok := req["max_tokens"]; ok {
+		out["max_tokens"] = v
+	}
+	// Chat Completions has no top_k, and rejects requests that set it.
+	for _, key := range []string{"temperature", "top_p"} {
+		if v, ok := req[key]; ok {
+			out[key] = v
+		}
+	}
+	if stop, ok := req["stop_sequences"].([]interface{}); ok && len(stop) > 0 {
+		out["stop"] = stop
+	}
+	if stream, _ := req["stream"].(bool); stream {
+		out["stream"] = true
+		out["stream_options"] = map[string]interface{}{"include_usage": true}
+	}
+	if metadata, ok := req["metadata"].(map[string]interface{}); ok {
+		if user, ok := metadata["user_id"].(string); ok && user != "" {
+			out["user"] = user
+		}
+	}
+
+	if tools := translateTools(req["tools"]); len(tools) > 0 {
+		out["tools"] = tools
+		if choice, ok := req["tool_choice"].(map[string]interface{}); ok {
+			translateToolChoice(choice, out)
+		}
+	}
+
+	return json.Marshal(out)
+}
+
+func systemText(system interface{}) string {
+	switch s := system.(type) {
+	case string:
+		return s
+	case []interface{}:
+		var parts []string
+		for _, block := range s {
+			if b, ok := block.(map[string]interface{}); ok && b["type"] == "text" {
+				if text, ok := b["text"].(string); ok {
+					parts = append(parts, text)
+				}
+			}
+		}
+		return strings.Join(parts, "\n\n")
+	}
+	return ""
+}
+
+// userMessages splits an Anthropic user turn into OpenAI messages. Tool
+// results become "tool" messages, which must directly follow the assistant
+// message that made the calls, so they are emitted first.
+func userMessages(content interface{}) []interface{} {
+	if text, ok := content.(string); ok {
+		return []interface{}{map[string]interface{}{"role": "user", "content": text}}
+	}
+
+	blocks, _ := content.([]interface{})
+	var toolMessages []interface{}
+	var parts []interface{}
+	for _, raw := range blocks {
+		block, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch block["type"] {
+		case "text":
+			parts = append(parts, map[string]interface{}{"type": "text", "text": block["text"]})
+		case "image":
+			if part := imagePart(block); part != nil {
+				parts = append(parts, part)
+			}
+		case "tool_result":
+			text, images := toolResultContent(block["content"])
+			if isError, _ := block["is_error"].(bool); isError {
+				text = "Error: " + text
+			}
+			toolMessages = append(toolMessages, map[string]interface{}{
+				"role":         "tool",
+				"tool_call_id": block["tool_use_id"],
+				"content":      text,
+			})
+			// Tool messages are text-only; images go in the user turn.
+			parts = append(parts, images...)
+		}
+	}
+
+	messages := toolMessages
+	if len(parts) > 0 {
+		messages = append(messages, map[string]interface{}{"role": "user", "content": parts})
+	}
+	return messages
+}
+
+func toolResultContent(content interface{}) (string, []interface{}) {
+	if text, ok := content.(string); ok {
+		return text, nil
+	}
+	blocks, _ := content.([]interface{})
+	var texts []string
+	var images []interface{}
+	for _, raw := range blocks {
+		block, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch block["type"] {
+		case "text":
+			if text, ok := block["text"].(string); ok {
+				texts = append(texts, text)
+			}
+		case "image":
+			if part := imagePart(block); part != nil {
+				images = append(images, part)
+			}
+		}
+	}
+	return strings.Join(texts, "\n"), images
+}
+
+func imagePart(block map[string]interface{}) map[string]interface{} {
+	source, ok := block["source"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	var url string
+	switch source["type"] {
+	case "base64":
+		url = fmt.Sprintf("data:%v;base64,%v", source["media_type"], source["data"])
+	case "url":
+		url, _ = source["url"].(string)
+	default:
+		return nil
+	}
+	return map[string]interface{}{
+		"type":      "image_url",
+		"image_url": map[string]interface{}{"url": url},
+	}
+}
+
+func assistantMessage(content interface{}) map[string]interface{} {
+	msg := map[string]interface{}{"role": "assistant"}
+	if text, ok := content.(string); ok {
+		msg["content"] = text
+		return msg
+	}
+
+	blocks, _ := content.([]interface{})
+	var texts []string
+	var toolCalls []interface{}
+	for _, raw := range blocks {
+		block, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch block["type"] {
+		case "text":
+			if text, ok := block["text"].(string); ok {
+				texts = append(texts, text)
+			}
+		case "tool_use":
+			args, _ := json.Marshal(block["input"])
+			toolCalls = append(toolCalls, map[string]interface{}{
+				"id":   block["id"],
+				"type": "function",
+				"function": map[string]interface{}{
+					"name":      block["name"],
+					"arguments": string(args),
+				},
+			})
+		}
+	}
+
+	if len(texts) > 0 {
+		msg["content"] = strings.Join(texts, "")
+	} else {
+		msg["content"] = nil
+	}
+	if len(toolCalls) > 0 {
+		msg["tool_calls"] = toolCalls
+	}
+	return msg
+}
+
+// translateTools converts client tool definitions. Anthropic server tools
+// (web search, code execution, ...) have no input_schema and are dropped.
+func translateTools(raw interface{}) []interface{} {
+	list, _ := raw.([]interface{})
+	var tools []interface{}
+	for _, item := range list {
+		tool, ok := item.(map[string]interface{})
+		if !ok || tool["input_schema"] == nil {
+			continue
+		}
+		fn := map[string]interface{}{
+			"name":       tool["name"],
+			"parameters": tool["input_schema"],
+		}
+		if desc, ok := tool["description"].(string); ok && desc != "" {
+			fn["description"] = desc
+		}
+		tools = append(tools, map[string]interface{}{"type": "function", "function": fn})
+	}
+	return tools
+}
+
+func translateToolChoice(choice map[string]interface{}, out map[string]interface{}) {
+	switch choice["type"] {
+	case "auto":
+		out["tool_choice"] = "auto"
+	case "any":
+		out["tool_choice"] = "required"
+	case "none":
+		out["tool_choice"] = "none"
+	case "tool":
+		out["tool_choice"] = map[string]interface{}{
+			"type":     "function",
+			"function": map[string]interface{}{"name": choice["name"]},
+		}
+	}
+	if disable, _ := choice["disable_parallel_tool_use"].(bool); disable {
+		out["parallel_tool_calls"] = false
+	}
+}
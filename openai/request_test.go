@@ -0,0 +1,96 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the expected files in testdata")
+
+// fixtures returns the base paths (without suffix) of testdata/dir/*suffix.
+func fixtures(t *testing.T, dir, suffix string) []string {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join("testdata", dir, "*"+suffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatalf("no fixtures in testdata/%s", dir)
+	}
+	for i, path := range paths {
+		paths[i] = strings.TrimSuffix(path, suffix)
+	}
+	return paths
+}
+
+// checkGolden compares got with the file at path, or writes it with -update.
+// JSON is compared by value, so key order and spacing don't matter.
+func checkGolden(t *testing.T, path string, got []byte) {
+	t.Helper()
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if !equalJSON(want, got) {
+		t.Errorf("%s mismatch\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func equalJSON(a, b []byte) bool {
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return bytes.Equal(a, b)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func indentJSON(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	if err := json.Indent(&out, data, "", "  "); err != nil {
+		t.Fatalf("invalid JSON %s: %v", data, err)
+	}
+	return append(out.Bytes(), '\n')
+}
+
+func TestTranslateRequest(t *testing.T) {
+	for _, base := range fixtures(t, "requests", ".anthropic.json") {
+		t.Run(filepath.Base(base), func(t *testing.T) {
+			data, err := os.ReadFile(base + ".anthropic.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var req map[string]interface{}
+			if err := json.Unmarshal(data, &req); err != nil {
+				t.Fatal(err)
+			}
+			got, err := TranslateRequest(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, base+".openai.json", indentJSON(t, got))
+		})
+	}
+}
+
+func TestTranslateRequestRejectsUnknownRole(t *testing.T) {
+	req := map[string]interface{}{
+		"model":    "m",
+		"messages": []interface{}{map[string]interface{}{"role": "system", "content": "x"}},
+	}
+	if _, err := TranslateRequest(req); err == nil {
+		t.Fatal("expected an error for role system")
+	}
+}
@@ -0,0 +1,148 @@
+package openai
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/schachte/claudecode-opencode-proxy/anthropic"
+)
+
+type chatUsage struct {
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	PromptTokensDetails struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+}
+
+type toolCall struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type chatResponse struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Message struct {
+			Content   *string    `json:"content"`
+			ToolCalls []toolCall `json:"tool_calls"`
+		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
+		// vLLM reports the matched stop string here.
+		StopReason interface{} `json:"stop_reason"`
+	} `json:"choices"`
+	Usage *chatUsage `json:"usage"`
+}
+
+// TranslateResponse converts a non-streaming Chat Completions response into an
+// Anthropic message. model is reported back instead of the upstream's name.
+func TranslateResponse(data []byte, model string) ([]byte, error) {
+	var resp chatResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("invalid chat completion: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("chat completion has no choices")
+	}
+	choice := resp.Choices[0]
+
+	content := []interface{}{}
+	if choice.Message.Content != nil && *choice.Message.Content != "" {
+		content = append(content, map[string]interface{}{"type": "text", "text": *choice.Message.Content})
+	}
+	for _, call := range choice.Message.ToolCalls {
+		content = append(content, map[string]interface{}{
+			"type":  "tool_use",
+			"id":    call.ID,
+			"name":  call.Function.Name,
+			"input": toolInput(call.Function.Arguments),
+		})
+	}
+
+	stopReason, stopSequence := stopReason(choice.FinishReason, choice.StopReason)
+	return json.Marshal(map[string]interface{}{
+		"id":            messageID(resp.ID),
+		"type":          "message",
+		"role":          "assistant",
+		"model":         model,
+		"content":       content,
+		"stop_reason":   stopReason,
+		"stop_sequence": stopSequence,
+		"usage":         anthropicUsage(resp.Usage),
+	})
+}
+
+// TranslateError converts an OpenAI-style error body into the Anthropic error
+// schema, keeping the upstream message when one can be found.
+func TranslateError(status int, data []byte) []byte {
+	message := strings.TrimSpace(string(data))
+	var body struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(data, &body) == nil && body.Error != nil {
+		var detail struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body.Error, &detail) == nil && detail.Message != "" {
+			message = detail.Message
+		} else {
+			var text string
+			if json.Unmarshal(body.Error, &text) == nil && text != "" {
+				message = text
+			}
+		}
+	}
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	return anthropic.ErrorBody(anthropic.ErrorType(status), message)
+}
+
+func stopReason(finishReason string, matched interface{}) (string, interface{}) {
+	switch finishReason {
+	case "length":
+		return "max_tokens", nil
+	case "tool_calls", "function_call":
+		return "tool_use", nil
+	case "stop":
+		if seq, ok := matched.(string); ok && seq != "" {
+			return "stop_sequence", seq
+		}
+	}
+	return "end_turn", nil
+}
+
+func anthropicUsage(u *chatUsage) map[string]interface{} {
+	usage := map[string]interface{}{"input_tokens": 0, "output_tokens": 0}
+	if u != nil {
+		cached := u.PromptTokensDetails.CachedTokens
+		usage["input_tokens"] = u.PromptTokens - cached
+		usage["output_tokens"] = u.CompletionTokens
+		if cached > 0 {
+			usage["cache_read_input_tokens"] = cached
+		}
+	}
+	return usage
+}
+
+func toolInput(arguments string) interface{} {
+	var input interface{}
+	if err := json.Unmarshal([]byte(arguments), &input); err != nil || input == nil {
+		return map[string]interface{}{}
+	}
+	return input
+}
+
+func messageID(id string) string {
+	if id == "" {
+		return "msg_openai"
+	}
+	return "msg_" + strings.TrimPrefix(id, "chatcmpl-")
+}
@@ -0,0 +1,60 @@
+package openai
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTranslateResponse(t *testing.T) {
+	for _, base := range fixtures(t, "responses", ".openai.json") {
+		t.Run(filepath.Base(base), func(t *testing.T) {
+			data, err := os.ReadFile(base + ".openai.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := TranslateResponse(data, "claude-sonnet-4")
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, base+".anthropic.json", indentJSON(t, got))
+		})
+	}
+}
+
+func TestTranslateResponseWithoutChoices(t *testing.T) {
+	if _, err := TranslateResponse([]byte(`{"id":"x","choices":[]}`), "m"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestTranslateError(t *testing.T) {
+	tests := []struct {
+		status  int
+		body    string
+		errType string
+		message string
+	}{
+		{http.StatusBadRequest, `{"error":{"message":"bad model","type":"invalid_request_error"}}`, "invalid_request_error", "bad model"},
+		{http.StatusTooManyRequests, `{"error":"slow down"}`, "rate_limit_error", "slow down"},
+		{http.StatusBadGateway, `upstream down`, "api_error", "upstream down"},
+		{http.StatusServiceUnavailable, ``, "overloaded_error", "Service Unavailable"},
+	}
+	for _, tt := range tests {
+		var got struct {
+			Type  string `json:"type"`
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(TranslateError(tt.status, []byte(tt.body)), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Type != "error" || got.Error.Type != tt.errType || got.Error.Message != tt.message {
+			t.Errorf("TranslateError(%d, %q) = %+v, want %s %q", tt.status, tt.body, got, tt.errType, tt.message)
+		}
+	}
+}
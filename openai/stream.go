@@ -0,0 +1,249 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/schachte/claudecode-opencode-proxy/anthropic"
+)
+
+// StreamTranslator converts a Chat Completions SSE stream into Anthropic
+// Messages SSE events, one upstream line at a time.
+type StreamTranslator struct {
+	model string
+
+	started   bool
+	done      bool
+	blockOpen bool
+	blockType string
+	index     int
+	toolBlock map[int]int // tool call index -> content block index
+
+	// Tool calls that start while another call's block is open are held
+	// here until the message ends, since their argument deltas may
+	// interleave with the open call's.
+	pending  []int
+	buffered map[int]*pendingCall
+
+	stopReason   string
+	stopSequence interface{}
+	usage        *chatUsage
+}
+
+type pendingCall struct {
+	id, name  string
+	arguments bytes.Buffer
+}
+
+type chatChunk struct {
+	ID      string `json:"id"`
+	Choices []struct {
+		Delta struct {
+			Content   string     `json:"content"`
+			ToolCalls []toolCall `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason *string     `json:"finish_reason"`
+		StopReason   interface{} `json:"stop_reason"`
+	} `json:"choices"`
+	Usage *chatUsage      `json:"usage"`
+	Error json.RawMessage `json:"error"`
+}
+
+func NewStreamTranslator(model string) *StreamTranslator {
+	return &StreamTranslator{model: model, index: -1, toolBlock: make(map[int]int), buffered: make(map[int]*pendingCall)}
+}
+
+// Translate consumes one upstream SSE line and returns the Anthropic events
+// it produces, which may be empty.
+func (t *StreamTranslator) Translate(line []byte) []byte {
+	line = bytes.TrimSpace(line)
+	if t.done || !bytes.HasPrefix(line, []byte("data:")) {
+		return nil
+	}
+	data := bytes.TrimSpace(bytes.TrimPrefix(line, []byte("data:")))
+	if bytes.Equal(data, []byte("[DONE]")) {
+		return t.Finish()
+	}
+
+	var chunk chatChunk
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		return nil
+	}
+
+	if chunk.Error != nil {
+		t.done = true
+		return anthropic.ErrorEvent("api_error", errorMessage(chunk.Error))
+	}
+
+	var out bytes.Buffer
+	if !t.started {
+		out.Write(t.start(chunk.ID))
+	}
+	if chunk.Usage != nil {
+		t.usage = chunk.Usage
+	}
+
+	for _, choice := range chunk.Choices {
+		if choice.Delta.Content != "" {
+			if !t.blockOpen || t.blockType != "text" {
+				out.Write(t.flushPending())
+				out.Write(t.openBlock("text", map[string]interface{}{"type": "text", "text": ""}))
+			}
+			out.Write(anthropic.Event("content_block_delta", map[string]interface{}{
+				"type":  "content_block_delta",
+				"index": t.index,
+				"delta": map[string]interface{}{"type": "text_delta", "text": choice.Delta.Content},
+			}))
+		}
+
+		for _, call := range choice.Delta.ToolCalls {
+			if blockIndex, ok := t.toolBlock[call.Index]; ok {
+				out.Write(argumentsDelta(blockIndex, call.Function.Arguments))
+				continue
+			}
+			if p, ok := t.buffered[call.Index]; ok {
+				p.arguments.WriteString(call.Function.Arguments)
+				continue
+			}
+			if t.blockOpen && t.blockType == "tool_use" {
+				p := &pendingCall{id: call.ID, name: call.Function.Name}
+				p.arguments.WriteString(call.Function.Arguments)
+				t.buffered[call.Index] = p
+				t.pending = append(t.pending, call.Index)
+				continue
+			}
+			out.Write(t.openTool(call.Index, call.ID, call.Function.Name))
+			out.Write(argumentsDelta(t.index, call.Function.Arguments))
+		}
+
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			t.stopReason, t.stopSequence = stopReason(*choice.FinishReason, choice.StopReason)
+		}
+	}
+	return out.Bytes()
+}
+
+// End is called when the upstream body ends. A stream that ends without
+// [DONE] or a finish_reason was cut short, so End returns io.ErrUnexpectedEOF
+// rather than closing the message as if it were complete.
+func (t *StreamTranslator) End() ([]byte, error) {
+	if t.done {
+		return nil, nil
+	}
+	if t.stopReason == "" {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return t.Finish(), nil
+}
+
+// Finish closes the message if the upstream has not already done so. It is
+// safe to call more than once.
+func (t *StreamTranslator) Finish() []byte {
+	if t.done {
+		return nil
+	}
+	t.done = true
+
+	var out bytes.Buffer
+	if !t.started {
+		out.Write(t.start(""))
+	}
+	out.Write(t.flushPending())
+	out.Write(t.closeBlock())
+
+	stop := t.stopReason
+	if stop == "" {
+		stop = "end_turn"
+	}
+	out.Write(anthropic.Event("message_delta", map[string]interface{}{
+		"type":  "message_delta",
+		"delta": map[string]interface{}{"stop_reason": stop, "stop_sequence": t.stopSequence},
+		"usage": anthropicUsage(t.usage),
+	}))
+	out.Write(anthropic.Event("message_stop", map[string]interface{}{"type": "message_stop"}))
+	return out.Bytes()
+}
+
+func (t *StreamTranslator) start(id string) []byte {
+	t.started = true
+	return anthropic.Event("message_start", map[string]interface{}{
+		"type": "message_start",
+		"message": map[string]interface{}{
+			"id":            messageID(id),
+			"type":          "message",
+			"role":          "assistant",
+			"model":         t.model,
+			"content":       []interface{}{},
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage":         map[string]interface{}{"input_tokens": 0, "output_tokens": 0},
+		},
+	})
+}
+
+func (t *StreamTranslator) openBlock(blockType string, block map[string]interface{}) []byte {
+	out := t.closeBlock()
+	t.index++
+	t.blockOpen = true
+	t.blockType = blockType
+	return append(out, anthropic.Event("content_block_start", map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         t.index,
+		"content_block": block,
+	})...)
+}
+
+func (t *StreamTranslator) openTool(callIndex int, id, name string) []byte {
+	out := t.openBlock("tool_use", map[string]interface{}{
+		"type":  "tool_use",
+		"id":    id,
+		"name":  name,
+		"input": map[string]interface{}{},
+	})
+	t.toolBlock[callIndex] = t.index
+	return out
+}
+
+// flushPending opens a block for each held tool call and sends its
+// arguments in one delta.
+func (t *StreamTranslator) flushPending() []byte {
+	var out bytes.Buffer
+	for _, callIndex := range t.pending {
+		p := t.buffered[callIndex]
+		delete(t.buffered, callIndex)
+		out.Write(t.openTool(callIndex, p.id, p.name))
+		out.Write(argumentsDelta(t.index, p.arguments.String()))
+	}
+	t.pending = nil
+	return out.Bytes()
+}
+
+func argumentsDelta(index int, arguments string) []byte {
+	if arguments == "" {
+		return nil
+	}
+	return anthropic.Event("content_block_delta", map[string]interface{}{
+		"type":  "content_block_delta",
+		"index": index,
+		"delta": map[string]interface{}{"type": "input_json_delta", "partial_json": arguments},
+	})
+}
+
+func (t *StreamTranslator) closeBlock() []byte {
+	if !t.blockOpen {
+		return nil
+	}
+	t.blockOpen = false
+	return anthropic.Event("content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": t.index})
+}
+
+func errorMessage(raw json.RawMessage) string {
+	var detail struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(raw, &detail) == nil && detail.Message != "" {
+		return detail.Message
+	}
+	return string(raw)
+}
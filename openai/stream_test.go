@@ -0,0 +1,83 @@
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// translateStream runs an upstream SSE body through a StreamTranslator line
+// by line, as the proxy does, and returns the output and End's error.
+func translateStream(t *testing.T, body []byte) ([]byte, error) {
+	t.Helper()
+	translator := NewStreamTranslator("claude-sonnet-4")
+	reader := bufio.NewReader(bytes.NewReader(body))
+	var out bytes.Buffer
+	for {
+		line, err := reader.ReadBytes('\n')
+		out.Write(translator.Translate(line))
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	closing, err := translator.End()
+	out.Write(closing)
+	return out.Bytes(), err
+}
+
+func TestStreamTranslator(t *testing.T) {
+	wantErr := map[string]error{
+		"truncated": io.ErrUnexpectedEOF,
+	}
+	for _, base := range fixtures(t, "streams", ".openai.sse") {
+		name := filepath.Base(base)
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(base + ".openai.sse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := translateStream(t, data)
+			if !errors.Is(err, wantErr[name]) || (err == nil) != (wantErr[name] == nil) {
+				t.Fatalf("End error = %v, want %v", err, wantErr[name])
+			}
+			checkGolden(t, base+".anthropic.sse", got)
+		})
+	}
+}
+
+func TestStreamTranslatorTruncated(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "streams", "truncated.openai.sse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := translateStream(t, data)
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("End error = %v, want io.ErrUnexpectedEOF", err)
+	}
+	for _, event := range []string{"message_delta", "message_stop"} {
+		if bytes.Contains(got, []byte("event: "+event)) {
+			t.Errorf("truncated stream must not be closed with %s:\n%s", event, got)
+		}
+	}
+	if !bytes.Contains(got, []byte(`"text":"Hel"`)) {
+		t.Errorf("content before the cut is missing:\n%s", got)
+	}
+}
+
+func TestStreamTranslatorFinishIsIdempotent(t *testing.T) {
+	translator := NewStreamTranslator("m")
+	translator.Translate([]byte(`data: {"id":"x","choices":[{"delta":{"content":"a"},"finish_reason":"stop"}]}`))
+	if first := translator.Translate([]byte("data: [DONE]")); !bytes.Contains(first, []byte("message_stop")) {
+		t.Fatalf("[DONE] did not close the message:\n%s", first)
+	}
+	if again, err := translator.End(); len(again) != 0 || err != nil {
+		t.Fatalf("End after [DONE] = %q, %v; want nothing", again, err)
+	}
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/schachte/claudecode-opencode-proxy/config"
+)
+
+// statusOverloaded is Anthropic's 529, which Claude Code retries.
+const statusOverloaded = 529
+
+// Circuit breaker states.
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+// breaker is a per-upstream circuit breaker. After threshold consecutive
+// failures it opens and requests skip the upstream. Once the cooldown has
+// passed it lets a single probe through (half-open); the probe's result
+// closes or re-opens the circuit.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probeAt  time.Time
+}
+
+func newBreaker(policy config.BreakerConfig) *breaker {
+	return &breaker{
+		threshold: policy.FailureThreshold,
+		cooldown:  time.Duration(policy.CooldownSeconds) * time.Second,
+		state:     circuitClosed,
+	}
+}
+
+// allow reports whether a request may be sent now. In the half-open state
+// only one probe is in flight at a time; a probe that never reports back
+// is replaced after another cooldown.
+func (b *breaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if now.Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = circuitHalfOpen
+		b.probeAt = now
+		return true
+	case circuitHalfOpen:
+		if now.Sub(b.probeAt) < b.cooldown {
+			return false
+		}
+		b.probeAt = now
+		return true
+	}
+	return true
+}
+
+// success closes the circuit and returns the previous state.
+func (b *breaker) success() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	prev := b.state
+	b.state = circuitClosed
+	b.failures = 0
+	return prev
+}
+
+// failure counts a failed attempt and returns the new state.
+func (b *breaker) failure(now time.Time) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == circuitHalfOpen || (b.state == circuitClosed && b.failures >= b.threshold) {
+		b.state = circuitOpen
+		b.openedAt = now
+	}
+	return b.state
+}
+
+func (b *breaker) current() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *breaker) isOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == circuitOpen
+}
+
+// stats reports breaker state for /health.
+func (b *breaker) stats(now time.Time) map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := map[string]interface{}{
+		"state":    b.state,
+		"failures": b.failures,
+	}
+	if b.state == circuitOpen {
+		stats["opened_at"] = b.openedAt.Format(time.RFC3339)
+		stats["retry_in_ms"] = max(b.cooldown-now.Sub(b.openedAt), 0).Milliseconds()
+	}
+	return stats
+}
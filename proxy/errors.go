@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/schachte/claudecode-opencode-proxy/anthropic"
+)
+
+// requestIDHeader is the header Anthropic uses to identify a request. The
+// proxy sets it on responses that don't carry the upstream's own.
+const requestIDHeader = "request-id"
+
+// newRequestID returns an ID in the style of Anthropic's, marked as the
+// proxy's so it is not mistaken for an upstream one.
+func newRequestID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "req_proxy_" + hex.EncodeToString(b)
+}
+
+// writeError sends a proxy-generated failure in the Anthropic error schema.
+// The error type follows from the status.
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(anthropic.ErrorBody(anthropic.ErrorType(status), message))
+}
+
+// maxErrorMessage is how many bytes of a non-Anthropic error body are kept.
+const maxErrorMessage = 500
+
+// normalizeError returns an upstream error body in the Anthropic schema.
+// Bodies already in that schema pass through; anything else, such as an
+// HTML page from a gateway, becomes the message of a new error.
+func normalizeError(status int, data []byte) ([]byte, bool) {
+	var body struct {
+		Type  string `json:"type"`
+		Error struct {
+			Type string `json:"type"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(data, &body) == nil && body.Type == "error" && body.Error.Type != "" {
+		return data, false
+	}
+	message := string(bytes.TrimSpace(data))
+	if len(message) > maxErrorMessage {
+		cut := maxErrorMessage
+		for cut > 0 && !utf8.RuneStart(message[cut]) {
+			cut--
+		}
+		message = message[:cut] + "..."
+	}
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	return anthropic.ErrorBody(anthropic.ErrorType(status), "Upstream error: "+message), true
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestNormalizeErrorPassesAnthropicErrors(t *testing.T) {
+	body := []byte(`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"},"request_id":"req_1"}`)
+	got, changed := normalizeError(statusOverloaded, body)
+	if changed || string(got) != string(body) {
+		t.Errorf("normalizeError = %s, %v; want the body unchanged", got, changed)
+	}
+}
+
+func TestNormalizeError(t *testing.T) {
+	long := strings.Repeat("a", maxErrorMessage-1) + "é" + strings.Repeat("b", 100)
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		errType string
+		message string
+	}{
+		{"html", http.StatusBadGateway, "<html>Bad gateway</html>\n", "api_error", "Upstream error: <html>Bad gateway</html>"},
+		{"empty", http.StatusServiceUnavailable, "", "overloaded_error", "Upstream error: Service Unavailable"},
+		{"other json", http.StatusTooManyRequests, `{"error":"slow down"}`, "rate_limit_error", `Upstream error: {"error":"slow down"}`},
+		// The cut falls inside "é", so it backs up to the rune's start.
+		{"truncated", http.StatusForbidden, long, "permission_error", "Upstream error: " + strings.Repeat("a", maxErrorMessage-1) + "..."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed := normalizeError(tt.status, []byte(tt.body))
+			if !changed {
+				t.Fatal("body not normalized")
+			}
+			if !utf8.Valid(got) {
+				t.Fatalf("body is not valid UTF-8: %q", got)
+			}
+			var e struct {
+				Type  string `json:"type"`
+				Error struct {
+					Type    string `json:"type"`
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(got, &e); err != nil {
+				t.Fatalf("invalid JSON %s: %v", got, err)
+			}
+			if e.Type != "error" || e.Error.Type != tt.errType || e.Error.Message != tt.message {
+				t.Errorf("normalizeError = %s", got)
+			}
+		})
+	}
+}
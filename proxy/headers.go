@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/schachte/claudecode-opencode-proxy/usage"
+)
+
+// blockedHeaders are never forwarded regardless of configuration: they either
+// describe the client connection or carry client credentials, which the proxy
+// replaces with the upstream's own auth.
+var blockedHeaders = map[string]bool{
+	"authorization":           true,
+	"x-api-key":               true,
+	"cf-access-token":         true,
+	"cf-access-client-id":     true,
+	"cf-access-client-secret": true,
+	"cookie":                  true,
+	"host":                    true,
+	"content-length":          true,
+	"accept-encoding":         true,
+	"connection":              true,
+	"keep-alive":              true,
+	"proxy-authorization":     true,
+	"proxy-connection":        true,
+	"te":                      true,
+	"trailer":                 true,
+	"transfer-encoding":       true,
+	"upgrade":                 true,
+
+	strings.ToLower(usage.ProjectHeader): true,
+}
+
+// copyRequestHeaders copies client headers matching the allowlist and not
+// matching the denylist onto the upstream request.
+func copyRequestHeaders(dst, src http.Header, allow, deny []string) {
+	for key, values := range src {
+		name := strings.ToLower(key)
+		if blockedHeaders[name] || !matchHeader(allow, name) || matchHeader(deny, name) {
+			continue
+		}
+		for _, value := range values {
+			dst.Add(key, value)
+		}
+	}
+}
+
+func matchHeader(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
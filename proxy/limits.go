@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/schachte/claudecode-opencode-proxy/config"
+)
+
+const defaultQueueTimeout = 60 * time.Second
+
+// errQueueTimeout is returned when a request waited too long for capacity.
+var errQueueTimeout = errors.New("timed out waiting for upstream rate limit")
+
+// tokenBucket refills at perMinute/60 tokens a second up to perMinute.
+type tokenBucket struct {
+	capacity float64
+	tokens   float64
+	rate     float64 // tokens per second
+	last     time.Time
+}
+
+func newTokenBucket(perMinute int) *tokenBucket {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &tokenBucket{
+		capacity: float64(perMinute),
+		tokens:   float64(perMinute),
+		rate:     float64(perMinute) / 60,
+		last:     time.Now(),
+	}
+}
+
+// take removes n tokens if available and otherwise returns how long until
+// they will be. Requests larger than the bucket wait for a full bucket.
+func (b *tokenBucket) take(n float64, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.tokens = math.Min(b.capacity, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	n = math.Min(n, b.capacity)
+	if b.tokens >= n {
+		b.tokens -= n
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) refund(n float64) {
+	if b != nil {
+		b.tokens = math.Min(b.capacity, b.tokens+n)
+	}
+}
+
+// limiter enforces one upstream's Limits. Waiters are served in arrival
+// order: each holds the turn channel while it waits for the buckets.
+type limiter struct {
+	requests *tokenBucket
+	tokens   *tokenBucket
+	slots    chan struct{}
+	turn     chan struct{}
+	timeout  time.Duration
+
+	mu       sync.Mutex
+	queued   int
+	inFlight int
+	waited   int
+	waitSum  time.Duration
+	waitMax  time.Duration
+	timeouts int
+}
+
+func newLimiter(limits *config.Limits) *limiter {
+	if limits == nil {
+		return nil
+	}
+	l := &limiter{
+		requests: newTokenBucket(limits.RequestsPerMinute),
+		tokens:   newTokenBucket(limits.InputTokensPerMinute),
+		turn:     make(chan struct{}, 1),
+		timeout:  time.Duration(limits.QueueTimeoutSeconds) * time.Second,
+	}
+	if limits.MaxConcurrent > 0 {
+		l.slots = make(chan struct{}, limits.MaxConcurrent)
+	}
+	if l.timeout <= 0 {
+		l.timeout = defaultQueueTimeout
+	}
+	return l
+}
+
+// acquire waits until a request with inputTokens may be sent. The returned
+// release must be called once the response is finished. depth is the queue
+// length seen on arrival, including this request.
+func (l *limiter) acquire(ctx context.Context, inputTokens int) (release func(), depth int, wait time.Duration, err error) {
+	if l == nil {
+		return func() {}, 0, 0, nil
+	}
+	start := time.Now()
+	l.mu.Lock()
+	l.queued++
+	depth = l.queued
+	l.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+	err = l.wait(ctx, float64(inputTokens))
+	wait = time.Since(start)
+
+	l.mu.Lock()
+	l.queued--
+	if err == nil {
+		l.inFlight++
+		l.waited++
+		l.waitSum += wait
+		if wait > l.waitMax {
+			l.waitMax = wait
+		}
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		l.timeouts++
+		err = errQueueTimeout
+	}
+	l.mu.Unlock()
+	if err != nil {
+		return nil, depth, wait, err
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if l.slots != nil {
+				<-l.slots
+			}
+			l.mu.Lock()
+			l.inFlight--
+			l.mu.Unlock()
+		})
+	}, depth, wait, nil
+}
+
+func (l *limiter) wait(ctx context.Context, inputTokens float64) error {
+	select {
+	case l.turn <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-l.turn }()
+
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		delay := l.requests.take(1, now)
+		if delay == 0 {
+			if delay = l.tokens.take(inputTokens, now); delay > 0 {
+				// Both are taken together, so hand the request back.
+				l.requests.refund(1)
+			}
+		}
+		l.mu.Unlock()
+		if delay == 0 {
+			break
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (l *limiter) depth() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.queued
+}
+
+// stats reports queue state for /health.
+func (l *limiter) stats() map[string]interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	avg := time.Duration(0)
+	if l.waited > 0 {
+		avg = l.waitSum / time.Duration(l.waited)
+	}
+	return map[string]interface{}{
+		"queued":         l.queued,
+		"in_flight":      l.inFlight,
+		"avg_wait_ms":    avg.Milliseconds(),
+		"max_wait_ms":    l.waitMax.Milliseconds(),
+		"queue_timeouts": l.timeouts,
+	}
+}
@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/schachte/claudecode-opencode-proxy/usage"
+)
+
+// LogOptions selects the proxy's log output.
+type LogOptions struct {
+	Format  string // "text" (default) or "json"
+	Level   string // debug, info (default), warn or error
+	Verbose bool   // shorthand for Level "debug"
+	Quiet   bool   // log nothing
+	File    string // write to this file, with rotation, instead of stderr
+}
+
+// Validate reports an unknown format or level.
+func (o LogOptions) Validate() error {
+	if _, err := o.level(); err != nil {
+		return err
+	}
+	switch o.Format {
+	case "", "text", "json":
+		return nil
+	}
+	return fmt.Errorf("unknown log format %q (use text or json)", o.Format)
+}
+
+func (o LogOptions) level() (slog.Level, error) {
+	if o.Verbose {
+		return slog.LevelDebug, nil
+	}
+	if o.Level == "" {
+		return slog.LevelInfo, nil
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(o.Level)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q (use debug, info, warn or error)", o.Level)
+	}
+	return level, nil
+}
+
+// eventLog emits one record per proxy event. Each record has an event name
+// ("start", "done", "retry", ...), a human-readable message and the event's
+// fields. Text output keeps the classic "[15:04:05] DONE   #3 ..." lines;
+// JSON output is one object per line for log shippers.
+type eventLog struct {
+	*slog.Logger
+}
+
+func newEventLog(w io.Writer, opts LogOptions) eventLog {
+	level, _ := opts.level()
+	if opts.Quiet {
+		w = io.Discard
+	}
+	if opts.Format == "json" {
+		return eventLog{slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))}
+	}
+	return eventLog{slog.New(&textHandler{out: log.New(w, "", log.LstdFlags), level: level})}
+}
+
+func (l eventLog) with(attrs ...any) eventLog {
+	return eventLog{l.Logger.With(attrs...)}
+}
+
+func (l eventLog) emit(level slog.Level, event, msg string, attrs []any) {
+	l.Log(context.Background(), level, msg, append([]any{"event", event}, attrs...)...)
+}
+
+func (l eventLog) debug(event, msg string, attrs ...any) { l.emit(slog.LevelDebug, event, msg, attrs) }
+func (l eventLog) info(event, msg string, attrs ...any)  { l.emit(slog.LevelInfo, event, msg, attrs) }
+func (l eventLog) warn(event, msg string, attrs ...any)  { l.emit(slog.LevelWarn, event, msg, attrs) }
+func (l eventLog) error(event, msg string, attrs ...any) { l.emit(slog.LevelError, event, msg, attrs) }
+
+// resultAttrs are the fields of a finished request.
+func resultAttrs(record usage.Record, bytes int64, elapsed time.Duration) []any {
+	return []any{
+		"upstream", record.Upstream,
+		"upstream_model", record.UpstreamModel,
+		"status", record.Status,
+		"stream", record.Stream,
+		"bytes", bytes,
+		"input_tokens", record.InputTokens,
+		"output_tokens", record.OutputTokens,
+		"cache_read_tokens", record.CacheReadTokens,
+		"cache_write_tokens", record.CacheWriteTokens,
+		"cost_usd", record.CostUSD,
+		"duration_ms", elapsed.Milliseconds(),
+	}
+}
+
+// textHandler renders records as the proxy's original log lines. Fields
+// other than the event name are left to the JSON format.
+type textHandler struct {
+	out   *log.Logger
+	level slog.Level
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	line := r.Message
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == "event" {
+			line = fmt.Sprintf("%-6s %s", strings.ToUpper(a.Value.String()), r.Message)
+			return false
+		}
+		return true
+	})
+	if r.Level < slog.LevelInfo {
+		line = "[DEBUG] " + line
+	}
+	h.out.Printf("[%s] %s", r.Time.Format("15:04:05"), line)
+	return nil
+}
+
+func (h *textHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *textHandler) WithGroup(string) slog.Handler      { return h }
@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/schachte/claudecode-opencode-proxy/config"
+	"github.com/schachte/claudecode-opencode-proxy/metrics"
+	"github.com/schachte/claudecode-opencode-proxy/usage"
+)
+
+// otherModel is the model label for names the config does not know.
+const otherModel = "other"
+
+// proxyMetrics are the series served on /metrics.
+type proxyMetrics struct {
+	registry  *metrics.Registry
+	requests  *metrics.CounterVec
+	duration  *metrics.HistogramVec
+	ttft      *metrics.HistogramVec
+	tokens    *metrics.CounterVec
+	cost      *metrics.CounterVec
+	streams   *metrics.GaugeVec
+	retries   *metrics.CounterVec
+	failovers *metrics.CounterVec
+
+	// models are the patterns a model label may come from; see model.
+	models []string
+}
+
+func newProxyMetrics(cfg config.Config, prices usage.PriceTable, names []string, breakers map[string]*breaker, limiters map[string]*limiter) *proxyMetrics {
+	reg := metrics.NewRegistry()
+	m := &proxyMetrics{
+		registry: reg,
+		models:   labelModels(cfg, prices),
+		requests: reg.Counter("claude_proxy_requests_total",
+			"Proxied requests by client model, upstream and response status.", "model", "upstream", "status"),
+		duration: reg.Histogram("claude_proxy_request_duration_seconds",
+			"Time from request arrival to the end of the response.", metrics.DefaultBuckets, "model", "upstream"),
+		ttft: reg.Histogram("claude_proxy_time_to_first_token_seconds",
+			"Time from request arrival to the first content delta of a stream.", metrics.DefaultBuckets, "model", "upstream"),
+		tokens: reg.Counter("claude_proxy_tokens_total",
+			"Tokens reported by upstreams, by kind (input, output, cache_read, cache_write).", "model", "kind"),
+		cost: reg.Counter("claude_proxy_cost_usd_total",
+			"Estimated spend in USD.", "model"),
+		streams: reg.Gauge("claude_proxy_active_streams",
+			"Streaming responses currently being relayed."),
+		retries: reg.Counter("claude_proxy_retries_total",
+			"Upstream attempts that were retried.", "upstream"),
+		failovers: reg.Counter("claude_proxy_failovers_total",
+			"Requests moved on from an upstream to the next one.", "upstream"),
+	}
+	m.streams.Set(0)
+
+	reg.GaugeFunc("claude_proxy_circuit_state",
+		"Circuit breaker state per upstream; 1 for the current state.", []string{"upstream", "state"},
+		func(emit func(float64, ...string)) {
+			for _, name := range names {
+				current := breakers[name].current()
+				for _, state := range []string{circuitClosed, circuitHalfOpen, circuitOpen} {
+					value := 0.0
+					if state == current {
+						value = 1
+					}
+					emit(value, name, state)
+				}
+			}
+		})
+	reg.GaugeFunc("claude_proxy_queue_depth",
+		"Requests waiting for an upstream rate limit.", []string{"upstream"},
+		func(emit func(float64, ...string)) {
+			for _, name := range names {
+				if lim := limiters[name]; lim != nil {
+					emit(float64(lim.depth()), name)
+				}
+			}
+		})
+	return m
+}
+
+// labelModels collects the model names and globs the config mentions: model
+// maps, routes, budgets and prices, which include the built-in Claude models.
+// They are sorted longest first, so the most specific glob labels a name.
+func labelModels(cfg config.Config, prices usage.PriceTable) []string {
+	seen := map[string]bool{"": true}
+	var models []string
+	add := func(pattern string) {
+		if !seen[pattern] {
+			seen[pattern] = true
+			models = append(models, pattern)
+		}
+	}
+	for pattern := range cfg.Models {
+		add(pattern)
+	}
+	for _, up := range cfg.ResolveUpstreams() {
+		for pattern := range up.Models {
+			add(pattern)
+		}
+	}
+	for _, route := range cfg.Routes {
+		add(route.Model)
+	}
+	for _, budget := range cfg.Budgets {
+		add(budget.Model)
+	}
+	for pattern := range prices {
+		add(pattern)
+	}
+	sort.Slice(models, func(i, j int) bool {
+		if len(models[i]) != len(models[j]) {
+			return len(models[i]) > len(models[j])
+		}
+		return models[i] < models[j]
+	})
+	return models
+}
+
+// model returns the label for a client model. Clients can send any name, so
+// labels only come from the config: the name when the config lists it
+// exactly, otherwise the longest glob it matches, or otherModel.
+func (m *proxyMetrics) model(name string) string {
+	if name == "" {
+		return name
+	}
+	for _, pattern := range m.models {
+		if pattern == name {
+			return name
+		}
+	}
+	for _, pattern := range m.models {
+		if globMatch(pattern, name) {
+			return pattern
+		}
+	}
+	return otherModel
+}
+
+// observe records a finished request from its ledger record.
+func (m *proxyMetrics) observe(rec usage.Record, elapsed time.Duration) {
+	model := m.model(rec.Model)
+	m.requests.Inc(model, rec.Upstream, strconv.Itoa(rec.Status))
+	m.duration.Observe(elapsed.Seconds(), model, rec.Upstream)
+	m.tokens.Add(float64(rec.InputTokens), model, "input")
+	m.tokens.Add(float64(rec.OutputTokens), model, "output")
+	m.tokens.Add(float64(rec.CacheReadTokens), model, "cache_read")
+	m.tokens.Add(float64(rec.CacheWriteTokens), model, "cache_write")
+	m.cost.Add(rec.CostUSD, model)
+}
@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/schachte/claudecode-opencode-proxy/bedrock"
+	"github.com/schachte/claudecode-opencode-proxy/config"
+	"github.com/schachte/claudecode-opencode-proxy/openai"
+	"github.com/schachte/claudecode-opencode-proxy/vertex"
+)
+
+// upstreamRequest is the path and body sent to one upstream. When translate
+// is set, it rewrites the upstream response in place so that it reads as an
+// Anthropic Messages response.
+type upstreamRequest struct {
+	path      string
+	body      []byte
+	translate func(resp *http.Response) error
+}
+
+// prepareUpstream adapts a request to the upstream's protocol. reqData is nil
+// when the client body is not a JSON object; such bodies pass through as-is.
+// model is the client's model name, reported back in translated responses.
+func prepareUpstream(cfg config.Config, r *http.Request, reqData map[string]interface{}, body []byte, model string) (upstreamRequest, error) {
+	path := r.URL.Path
+	if reqData == nil {
+		return upstreamRequest{path: path, body: body}, nil
+	}
+
+	if cfg.AuthType == "bedrock" && path == "/v1/messages" {
+		upstreamModel, _ := reqData["model"].(string)
+		if upstreamModel == "" {
+			return upstreamRequest{}, fmt.Errorf("bedrock requests need a model")
+		}
+		data, err := bedrock.TranslateRequest(reqData, r.Header.Get("anthropic-beta"))
+		if err != nil {
+			return upstreamRequest{}, err
+		}
+		streaming, _ := reqData["stream"].(bool)
+		return upstreamRequest{
+			path: bedrock.InvokePath(upstreamModel, streaming),
+			body: data,
+			translate: func(resp *http.Response) error {
+				return translateBedrock(resp, streaming)
+			},
+		}, nil
+	}
+
+	if cfg.AuthType == "vertex" && path == "/v1/messages" {
+		upstreamModel, _ := reqData["model"].(string)
+		if upstreamModel == "" {
+			return upstreamRequest{}, fmt.Errorf("vertex requests need a model")
+		}
+		if cfg.Vertex == nil || cfg.Vertex.ProjectID == "" {
+			return upstreamRequest{}, fmt.Errorf("vertex.project_id is not configured")
+		}
+		data, err := vertex.TranslateRequest(reqData)
+		if err != nil {
+			return upstreamRequest{}, err
+		}
+		streaming, _ := reqData["stream"].(bool)
+		return upstreamRequest{
+			path:      vertex.PredictPath(cfg.Vertex.ProjectID, cfg.Vertex.Region, upstreamModel, streaming),
+			body:      data,
+			translate: translateVertex,
+		}, nil
+	}
+
+	if cfg.Protocol == "openai" && path == "/v1/messages" {
+		data, err := openai.TranslateRequest(reqData)
+		if err != nil {
+			return upstreamRequest{}, err
+		}
+		streaming, _ := reqData["stream"].(bool)
+		return upstreamRequest{
+			path: openai.ChatCompletionsPath,
+			body: data,
+			translate: func(resp *http.Response) error {
+				return translateOpenAI(resp, streaming, model)
+			},
+		}, nil
+	}
+
+	data, err := json.Marshal(reqData)
+	return upstreamRequest{path: path, body: data}, err
+}
+
+func translateOpenAI(resp *http.Response, streaming bool, model string) error {
+	if streaming && resp.StatusCode == http.StatusOK {
+		translator := openai.NewStreamTranslator(model)
+		replaceBody(resp, &translatingReader{
+			src:       bufio.NewReader(resp.Body),
+			closer:    resp.Body,
+			translate: translator.Translate,
+			end:       translator.End,
+		}, "text/event-stream")
+		return nil
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusOK {
+		if data, err = openai.TranslateResponse(data, model); err != nil {
+			return err
+		}
+	} else {
+		data = openai.TranslateError(resp.StatusCode, data)
+	}
+	replaceBody(resp, io.NopCloser(bytes.NewReader(data)), "application/json")
+	return nil
+}
+
+func translateBedrock(resp *http.Response, streaming bool) error {
+	if resp.StatusCode != http.StatusOK {
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		data = bedrock.TranslateError(resp.StatusCode, resp.Header.Get("X-Amzn-ErrorType"), data)
+		replaceBody(resp, io.NopCloser(bytes.NewReader(data)), "application/json")
+		return nil
+	}
+	if streaming {
+		replaceBody(resp, bedrock.NewSSEReader(resp.Body), "text/event-stream")
+	}
+	return nil
+}
+
+func translateVertex(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	replaceBody(resp, io.NopCloser(bytes.NewReader(vertex.TranslateError(resp.StatusCode, data))), "application/json")
+	return nil
+}
+
+// replaceBody swaps in a translated body. The upstream length no longer
+// applies, so it is dropped.
+func replaceBody(resp *http.Response, body io.ReadCloser, contentType string) {
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Header.Set("Content-Type", contentType)
+	resp.Body = body
+}
+
+// activityBody calls onRead whenever the upstream delivers bytes, before
+// any translation.
+type activityBody struct {
+	io.ReadCloser
+	onRead func()
+}
+
+func (a *activityBody) Read(p []byte) (int, error) {
+	n, err := a.ReadCloser.Read(p)
+	if n > 0 && a.onRead != nil {
+		a.onRead()
+	}
+	return n, err
+}
+
+// translatingReader feeds an upstream body through a line translator and
+// reads back the translated bytes. At the end of the body, end returns the
+// closing events, or an error (after which nothing is emitted) when the
+// upstream stream was incomplete.
+type translatingReader struct {
+	src       *bufio.Reader
+	closer    io.Closer
+	translate func(line []byte) []byte
+	end       func() ([]byte, error)
+	buf       []byte
+	err       error
+}
+
+func (t *translatingReader) Read(p []byte) (int, error) {
+	for len(t.buf) == 0 {
+		if t.err != nil {
+			return 0, t.err
+		}
+		line, err := t.src.ReadBytes('\n')
+		if len(line) > 0 {
+			t.buf = append(t.buf, t.translate(line)...)
+		}
+		if err == io.EOF {
+			closing, endErr := t.end()
+			t.buf = append(t.buf, closing...)
+			t.err = io.EOF
+			if endErr != nil {
+				t.err = endErr
+			}
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, t.buf)
+	t.buf = t.buf[n:]
+	return n, nil
+}
+
+func (t *translatingReader) Close() error {
+	return t.closer.Close()
+}
@@ -3,194 +3,865 @@ package proxy
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/schachte/claudecode-opencode-proxy/anthropic"
+	"github.com/schachte/claudecode-opencode-proxy/bedrock"
+	"github.com/schachte/claudecode-opencode-proxy/capture"
 	"github.com/schachte/claudecode-opencode-proxy/config"
+	"github.com/schachte/claudecode-opencode-proxy/logfile"
+	"github.com/schachte/claudecode-opencode-proxy/tracing"
+	"github.com/schachte/claudecode-opencode-proxy/usage"
 )
 
-func Run(port int, bindAddr string, verbose bool, quiet bool) {
+// Run loads the config and serves the proxy on bindAddr:port until the
+// process exits.
+func Run(port int, bindAddr string, logs LogOptions, captureDir string) {
 	cfg := config.LoadConfig()
+	var out, logOut io.Writer = os.Stdout, os.Stderr
+	if logs.File != "" {
+		rotate := cfg.LogPolicy()
+		f, err := logfile.Open(logs.File, logfile.Policy{
+			MaxBytes: int64(rotate.MaxSizeMB) << 20,
+			MaxAge:   time.Duration(rotate.MaxAgeHours) * time.Hour,
+			Keep:     rotate.MaxFiles,
+			Compress: *rotate.Compress,
+		})
+		if err != nil {
+			log.Fatalf("Failed to open log file: %v", err)
+		}
+		defer f.Close()
+		log.SetOutput(f)
+		out, logOut = f, f
+	}
+	logger := newEventLog(logOut, logs)
+	h, err := New(cfg, Options{Logger: logger.Logger, CaptureDir: captureDir})
+	if err != nil {
+		log.Fatalf("Failed to create proxy: %v", err)
+	}
+
+	addr := fmt.Sprintf("%s:%d", bindAddr, port)
+	if logs.Format == "json" {
+		h.logger.info("listening", "Proxy: http://"+addr+" -> "+h.upstreams[0].Target,
+			"addr", addr, "upstreams", h.names, "tracing", h.tracer.Endpoint())
+	} else {
+		fmt.Fprintf(out, "Proxy: http://%s -> %s\n", addr, h.upstreams[0].Target)
+		fmt.Fprintf(out, "Auth: %s, CF-Access: %v\n", h.upstreams[0].AuthType, h.upstreams[0].CfAccess)
+		for i, up := range h.upstreams[1:] {
+			fmt.Fprintf(out, "Failover %d: %s -> %s (auth: %s)\n", i+1, up.Name, up.Target, up.AuthType)
+		}
+		for _, state := range h.budgets.Snapshot(time.Now()) {
+			fmt.Fprintln(out, "Budget "+state.Message())
+		}
+		if endpoint := h.tracer.Endpoint(); endpoint != "" {
+			fmt.Fprintf(out, "Tracing: %s\n", endpoint)
+		}
+		if h.logger.Enabled(context.Background(), slog.LevelDebug) {
+			fmt.Fprintln(out, "Verbose: on")
+		}
+		fmt.Fprintln(out)
+	}
+
+	if err := http.ListenAndServe(addr, h); err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+}
+
+// Options configure a Handler beyond what the config file holds.
+type Options struct {
+	// Logger receives the request log. When nil, one is built from Logs
+	// and written to LogOutput, or os.Stderr.
+	Logger    *slog.Logger
+	Logs      LogOptions
+	LogOutput io.Writer
+	// CaptureDir, when set, is where each exchange is saved (see --capture).
+	CaptureDir string
+	// UsageFile is the ledger requests are appended to and budget spend is
+	// seeded from; empty means config.UsageFile.
+	UsageFile string
+	// Ledger, when set, receives the usage records instead of UsageFile.
+	Ledger io.Writer
+	// Budgets, when set, replaces the budgets built from cfg and UsageFile.
+	Budgets *usage.Budgets
+}
+
+// Handler serves the proxy endpoints: /health, /metrics and everything else
+// forwarded to the configured upstreams.
+type Handler struct {
+	mux       *http.ServeMux
+	logger    eventLog
+	upstreams []config.Upstream
+	names     []string
+	budgets   *usage.Budgets
+	tracer    *tracing.Tracer
+	ledger    *usage.Ledger
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// Close exports pending spans, stops the tracer and closes the ledger. The
+// Handler must not serve requests afterwards.
+func (h *Handler) Close() error {
+	h.tracer.Close()
+	return h.ledger.Close()
+}
+
+// New builds the proxy for cfg. Each Handler keeps its own request counter,
+// circuit breakers, rate limiters and metrics, so several can run in one
+// process.
+func New(cfg config.Config, opts Options) (*Handler, error) {
+	captureDir := opts.CaptureDir
+	logger := eventLog{opts.Logger}
+	if opts.Logger == nil {
+		logOut := opts.LogOutput
+		if logOut == nil {
+			logOut = os.Stderr
+		}
+		logger = newEventLog(logOut, opts.Logs)
+	}
+	upstreams := cfg.ResolveUpstreams()
 	var lastModel string
 	var requestCount int
+	var mu sync.Mutex
+	activeUpstream := upstreams[0].Name
+	credentials := make(map[string]credentialState)
+	usageStats := usage.NewAggregate()
+	usageFile := opts.UsageFile
+	if usageFile == "" {
+		usageFile = config.UsageFile
+	}
+	ledger := usage.NewLedger(usageFile)
+	if opts.Ledger != nil {
+		ledger = usage.NewLedgerWriter(opts.Ledger)
+	}
+	prices := usage.Prices(cfg.Prices)
+	budgets := opts.Budgets
+	if budgets == nil {
+		budgets = loadBudgets(cfg, prices, usageFile, logger)
+	}
+	limiters := make(map[string]*limiter)
+	breakers := make(map[string]*breaker)
+	for _, up := range upstreams {
+		limiters[up.Name] = newLimiter(up.Limits)
+		breakers[up.Name] = newBreaker(cfg.BreakerPolicy())
+	}
+	names := make([]string, len(upstreams))
+	for i, up := range upstreams {
+		names[i] = up.Name
+	}
+	stats := newProxyMetrics(cfg, prices, names, breakers, limiters)
 
+	timeouts := cfg.TimeoutPolicy()
+	idleTimeout := time.Duration(timeouts.IdleSeconds) * time.Second
+	totalTimeout := time.Duration(*timeouts.TotalSeconds) * time.Second
 	client, err := config.CreateHTTPClient(cfg)
 	if err != nil {
-		log.Fatalf("Failed to create HTTP client: %v", err)
+		return nil, fmt.Errorf("creating HTTP client: %w", err)
 	}
+	tracer := tracing.NewTracer(cfg.TracingEndpoint(), cfg.TracingServiceName(), cfg.TracingHeaders(), logger.Logger)
 
-	timestamp := func() string {
-		return time.Now().Format("15:04:05")
+	setActive := func(name string) {
+		mu.Lock()
+		changed := name != activeUpstream
+		activeUpstream = name
+		mu.Unlock()
+		if changed {
+			logger.info("active", name, "upstream", name)
+		}
 	}
 
-	logInfo := func(format string, args ...interface{}) {
-		if !quiet {
-			log.Printf("[%s] %s", timestamp(), fmt.Sprintf(format, args...))
+	// recordSpend prices u at the upstream model that served it and counts
+	// it against the requested model, the name budgets are written for.
+	recordSpend := func(model, upstreamModel string, u usage.Usage) float64 {
+		cost := prices.ServedCost(model, upstreamModel, u)
+		usageStats.Record(model, u, cost)
+		for _, state := range budgets.Add(model, cost, time.Now()) {
+			logger.warn("budget", strings.ToUpper(state.Level)+" "+state.Message(),
+				"model", model, "level", state.Level, "spent_usd", state.Spent)
 		}
+		return cost
 	}
 
-	logDebug := func(format string, args ...interface{}) {
-		if verbose && !quiet {
-			log.Printf("[%s] [DEBUG] %s", timestamp(), fmt.Sprintf(format, args...))
+	// reportCircuit feeds an attempt's outcome to the upstream's breaker.
+	// Connection errors and 5xx count as failures; other failures, such as
+	// auth errors, say nothing about the upstream's health.
+	reportCircuit := func(name string, res *http.Response, failure *attemptError) {
+		br := breakers[name]
+		switch {
+		case (failure != nil && failure.retry) || (res != nil && res.StatusCode >= 500):
+			prev := br.isOpen()
+			if br.failure(time.Now()) == circuitOpen && !prev {
+				logger.warn("circuit", fmt.Sprintf("[%s] open", name), "upstream", name, "state", "open")
+			}
+		case res != nil:
+			if prev := br.success(); prev != circuitClosed {
+				logger.info("circuit", fmt.Sprintf("[%s] closed", name), "upstream", name, "state", "closed")
+			}
 		}
 	}
 
 	handleProxy := func(w http.ResponseWriter, r *http.Request) {
 		startTime := time.Now()
+		mu.Lock()
 		requestCount++
 		reqID := requestCount
+		mu.Unlock()
 
-		logDebug("REQ #%d %s %s", reqID, r.Method, r.URL.Path)
+		requestID := newRequestID()
+		w.Header().Set(requestIDHeader, requestID)
+		rlog := logger.with("request", reqID, "request_id", requestID)
+		rlog.debug("req", fmt.Sprintf("#%d %s %s id=%s", reqID, r.Method, r.URL.Path, requestID), "method", r.Method, "path", r.URL.Path)
 
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
-			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, "Failed to read request body")
 			return
 		}
 		defer r.Body.Close()
 
+		var exchange *capture.Exchange
+		if captureDir != "" {
+			exchange = capture.New(requestID, startTime, r, body)
+			w = exchange.Writer(w)
+		}
+
 		var reqData map[string]interface{}
 		isStreaming := false
 		model := ""
 		if len(body) > 0 {
 			if err := json.Unmarshal(body, &reqData); err == nil {
-				delete(reqData, "context_management")
-				delete(reqData, "mcp_servers")
 				if stream, ok := reqData["stream"].(bool); ok {
 					isStreaming = stream
 				}
 				if m, ok := reqData["model"].(string); ok {
 					model = m
 				}
-				body, _ = json.Marshal(reqData)
 			}
 		}
 
-		if model != "" && model != lastModel {
-			logInfo("MODEL  %s", model)
-			lastModel = model
+		candidates := routeUpstreams(cfg, upstreams, model)
+		rlog = rlog.with("model", model)
+		exchange.SetModel(model)
+		if model != "" {
+			modelLine := model
+			if mapped := mapModel(cfg, candidates[0], model); mapped != model {
+				modelLine = fmt.Sprintf("%s -> %s [%s]", model, mapped, candidates[0].Name)
+			}
+			mu.Lock()
+			changed := modelLine != lastModel
+			lastModel = modelLine
+			mu.Unlock()
+			if changed {
+				rlog.info("model", modelLine, "upstream", candidates[0].Name)
+			}
 		}
 
 		streamType := "sync"
 		if isStreaming {
 			streamType = "stream"
 		}
-		logInfo("START  #%d [%s]", reqID, streamType)
+		startLine := fmt.Sprintf("#%d [%s]", reqID, streamType)
+		if model != "" {
+			startLine += " model=" + model
+		}
+		rlog.info("start", startLine+" id="+requestID, "stream", isStreaming, "path", r.URL.Path)
 
-		logDebug("REQ #%d model=%s stream=%v", reqID, model, isStreaming)
+		record := usage.Record{
+			Time:      startTime,
+			Request:   reqID,
+			RequestID: requestID,
+			Model:     model,
+			Path:      r.URL.Path,
+			Stream:    isStreaming,
+			Project:   r.Header.Get(usage.ProjectHeader),
+		}
+		parent, _ := tracing.ParseTraceparent(r.Header.Get("traceparent"))
+		span := tracer.Start(r.Method+" "+r.URL.Path, tracing.KindServer, parent)
+		span.SetAttr("http.request.method", r.Method)
+		span.SetAttr("url.path", r.URL.Path)
+		span.SetAttr("gen_ai.request.model", model)
+		span.SetAttr("claude_proxy.stream", isStreaming)
+		span.SetAttr("claude_proxy.request", reqID)
 
-		token, authType, err := config.GetToken(cfg)
-		if err != nil {
-			logInfo("ERROR  #%d auth failed: %v", reqID, err)
-			http.Error(w, "Failed to get auth token", http.StatusInternalServerError)
-			return
+		defer func() {
+			elapsed := time.Since(startTime)
+			record.LatencyMs = elapsed.Milliseconds()
+			stats.observe(record, elapsed)
+			span.SetAttr("http.response.status_code", record.Status)
+			span.SetAttr("claude_proxy.request_id", record.RequestID)
+			span.SetAttr("claude_proxy.upstream", record.Upstream)
+			span.SetAttr("gen_ai.response.model", record.UpstreamModel)
+			span.SetAttr("gen_ai.usage.input_tokens", record.InputTokens)
+			span.SetAttr("gen_ai.usage.output_tokens", record.OutputTokens)
+			span.SetAttr("gen_ai.usage.cache_read_input_tokens", record.CacheReadTokens)
+			span.SetAttr("gen_ai.usage.cache_creation_input_tokens", record.CacheWriteTokens)
+			span.SetAttr("claude_proxy.cost_usd", record.CostUSD)
+			if record.Canceled {
+				span.SetAttr("claude_proxy.canceled", true)
+			}
+			if record.Status >= 500 {
+				span.SetError(http.StatusText(record.Status))
+			}
+			span.End()
+			if err := ledger.Append(record); err != nil {
+				rlog.debug("ledger", fmt.Sprintf("#%d write failed: %v", reqID, err), "error", err.Error())
+			}
+			if err := exchange.Save(captureDir, record.RequestID); err != nil {
+				rlog.warn("capture", fmt.Sprintf("#%d write failed: %v", reqID, err), "error", err.Error())
+			}
+		}()
+
+		// Rejections are recorded like any other request, so they show up
+		// in the ledger, metrics and traces.
+		if r.URL.Path == "/v1/messages" {
+			if state, exceeded := budgets.Check(model, time.Now()); exceeded {
+				record.Status, record.Budget = http.StatusTooManyRequests, state.Label()
+				span.SetAttr("claude_proxy.budget", state.Label())
+				rlog.warn("budget", fmt.Sprintf("#%d rejected: %s", reqID, state.Message()), "status", http.StatusTooManyRequests, "budget", state.Label())
+				writeError(w, http.StatusTooManyRequests, "Proxy spend limit reached: "+state.Message())
+				return
+			}
 		}
 
-		upstreamURL := cfg.Target + r.URL.Path
-		logDebug("PROXY  #%d -> %s", reqID, upstreamURL)
+		// outcome summarizes a finished request in text log lines, naming
+		// the upstream's request-id when it replaced ours.
+		outcome := func() string {
+			line := fmt.Sprintf("status=%d", record.Status)
+			if record.RequestID != requestID {
+				line += " id=" + record.RequestID
+			}
+			return line
+		}
 
-		upstreamReq, err := http.NewRequest(r.Method, upstreamURL, bytes.NewReader(body))
-		if err != nil {
-			http.Error(w, "Failed to create upstream request", http.StatusInternalServerError)
-			return
+		// logCancel records a client disconnect. The upstream request is
+		// bound to r.Context(), so it has already been aborted.
+		logCancel := func(detail string) {
+			record.Status, record.Canceled = statusClientClosed, true
+			rlog.info("cancel", fmt.Sprintf("#%d [%s] %s %v %s", reqID, streamType, outcome(), time.Since(startTime).Round(time.Millisecond), detail),
+				"upstream", record.Upstream, "status", record.Status, "duration_ms", time.Since(startTime).Milliseconds())
 		}
 
-		upstreamReq.Header.Set("Content-Type", "application/json")
-		upstreamReq.Header.Set("anthropic-version", "2023-06-01")
+		// send makes one attempt. Each attempt takes its own rate-limit slot
+		// and only then builds the request, so a token or signature is never
+		// left to go stale in the queue.
+		send := func(up config.Upstream, ucfg config.Config, upstreamURL string, payload []byte, attemptSpan *tracing.Span) (*http.Response, func(), *attemptError) {
+			release, depth, wait, err := limiters[up.Name].acquire(r.Context(), len(payload)/4)
+			if depth > 1 || wait >= time.Millisecond {
+				rlog.debug("queue", fmt.Sprintf("#%d [%s] depth=%d waited %v", reqID, up.Name, depth, wait.Round(time.Millisecond)),
+					"upstream", up.Name, "depth", depth, "wait_ms", wait.Milliseconds())
+				now := time.Now()
+				attemptSpan.ChildAt("queue", tracing.KindInternal, now.Add(-wait)).EndAt(now)
+			}
+			if err != nil {
+				return nil, nil, &attemptError{http.StatusTooManyRequests, "Timed out waiting for upstream rate limit", false,
+					fmt.Errorf("%w after %v", err, wait.Round(time.Millisecond))}
+			}
 
-		if cfg.CfAccess {
-			upstreamReq.Header.Set("cf-access-token", token)
-			if cfg.CfClientID != "" && cfg.CfClientSecret != "" {
-				upstreamReq.Header.Set("CF-Access-Client-Id", cfg.CfClientID)
-				upstreamReq.Header.Set("CF-Access-Client-Secret", cfg.CfClientSecret)
+			// The total deadline runs from here until the response body is
+			// released, so it bounds both the wait for headers and the stream.
+			ctx, cancel := r.Context(), context.CancelFunc(func() {})
+			if totalTimeout > 0 {
+				ctx, cancel = context.WithTimeout(ctx, totalTimeout)
 			}
-		} else if authType == "apikey" {
-			upstreamReq.Header.Set("x-api-key", token)
-		} else {
-			upstreamReq.Header.Set("Authorization", "Bearer "+token)
+			freeSlot := release
+			release = func() {
+				cancel()
+				freeSlot()
+			}
+
+			authSpan := attemptSpan.Child("auth", tracing.KindInternal)
+			token, authType, err := config.GetToken(ucfg)
+			if err != nil {
+				authSpan.SetError(err.Error())
+			}
+			authSpan.End()
+			mu.Lock()
+			credentials[up.Name] = newCredentialState(token, err)
+			mu.Unlock()
+			if err != nil {
+				release()
+				return nil, nil, &attemptError{http.StatusUnauthorized, "Proxy failed to get upstream auth token: " + err.Error(), false, fmt.Errorf("auth failed: %w", err)}
+			}
+			upstreamReq, err := http.NewRequestWithContext(ctx, r.Method, upstreamURL, bytes.NewReader(payload))
+			if err != nil {
+				release()
+				return nil, nil, &attemptError{http.StatusInternalServerError, "Failed to create upstream request", false, err}
+			}
+			copyRequestHeaders(upstreamReq.Header, r.Header, cfg.ForwardHeaders, cfg.DropHeaders)
+			if sc := attemptSpan.Context(); sc.IsValid() {
+				upstreamReq.Header.Set("traceparent", sc.Traceparent())
+			} else if traceparent := r.Header.Get("traceparent"); traceparent != "" {
+				upstreamReq.Header.Set("traceparent", traceparent)
+			}
+			if tracestate := r.Header.Get("tracestate"); tracestate != "" {
+				upstreamReq.Header.Set("tracestate", tracestate)
+			}
+			upstreamReq.Header.Set("Content-Type", "application/json")
+			if upstreamReq.Header.Get("anthropic-version") == "" {
+				upstreamReq.Header.Set("anthropic-version", "2023-06-01")
+			}
+			if err := authorize(upstreamReq, ucfg, token, authType, payload); err != nil {
+				release()
+				return nil, nil, &attemptError{http.StatusUnauthorized, "Proxy failed to get upstream auth token: " + err.Error(), false, fmt.Errorf("auth failed: %w", err)}
+			}
+			exchange.SetUpstream(up.Name, upstreamReq.Method, upstreamURL, upstreamReq.Header, payload)
+
+			upstreamReq, finishTrace := traceAttempt(upstreamReq, attemptSpan)
+			res, err := client.Do(upstreamReq)
+			finishTrace()
+			if err != nil {
+				release()
+				return nil, nil, &attemptError{http.StatusBadGateway, fmt.Sprintf("Upstream request failed: %v", err), true,
+					fmt.Errorf("upstream failed: %w", err)}
+			}
+			return res, release, nil
 		}
 
-		resp, err := client.Do(upstreamReq)
-		if err != nil {
-			logInfo("ERROR  #%d upstream failed: %v", reqID, err)
-			http.Error(w, fmt.Sprintf("Upstream request failed: %v", err), http.StatusBadGateway)
+		policy := cfg.RetryPolicy()
+		var resp *http.Response
+		var upstreamBody *activityBody
+		releaseSlot := func() {}
+		defer func() { releaseSlot() }()
+		failStatus := http.StatusBadGateway
+		failMsg := "No upstream available"
+		skipped := 0
+		for i, up := range candidates {
+			last := i == len(candidates)-1
+			ucfg := cfg.ForUpstream(up)
+
+			if !breakers[up.Name].allow(time.Now()) {
+				rlog.warn("skip", fmt.Sprintf("#%d [%s] circuit open", reqID, up.Name), "upstream", up.Name)
+				skipped++
+				continue
+			}
+
+			// Rules run per upstream, on a copy, since they can be limited to
+			// one upstream. A rule may replace the model before mapping.
+			var data map[string]interface{}
+			requested := model
+			if reqData != nil {
+				data = cloneBody(reqData)
+				if applied := ApplyRules(cfg.Rules, r.URL.Path, up.Name, data); len(applied) > 0 {
+					rlog.debug("rules", fmt.Sprintf("#%d [%s] %s", reqID, up.Name, strings.Join(applied, ", ")), "upstream", up.Name, "rules", applied)
+				}
+				if m, ok := data["model"].(string); ok {
+					requested = m
+				}
+			}
+			mapped := mapModel(cfg, up, requested)
+			if mapped != requested {
+				data["model"] = mapped
+			}
+			if mapped != model {
+				rlog.debug("model", fmt.Sprintf("#%d %s -> %s (%s)", reqID, model, mapped, up.Name), "upstream", up.Name, "upstream_model", mapped)
+			}
+			prepared, err := prepareUpstream(ucfg, r, data, body, model)
+			if err != nil {
+				rlog.error("error", fmt.Sprintf("#%d [%s] cannot translate request: %v", reqID, up.Name, err), "upstream", up.Name, "error", err.Error())
+				failStatus, failMsg = http.StatusBadRequest, fmt.Sprintf("Failed to translate request: %v", err)
+				continue
+			}
+
+			upstreamURL := up.Target + prepared.path
+			if r.URL.RawQuery != "" && prepared.path == r.URL.Path {
+				upstreamURL += "?" + r.URL.RawQuery
+			}
+			rlog.debug("proxy", fmt.Sprintf("#%d -> %s (%s)", reqID, upstreamURL, up.Name), "upstream", up.Name, "url", upstreamURL)
+
+			var res *http.Response
+			var release func()
+			var failure *attemptError
+			for attempt := 1; ; attempt++ {
+				attemptSpan := span.Child("upstream "+up.Name, tracing.KindClient)
+				attemptSpan.SetAttr("claude_proxy.upstream", up.Name)
+				attemptSpan.SetAttr("url.full", upstreamURL)
+				attemptSpan.SetAttr("claude_proxy.attempt", attempt)
+				res, release, failure = send(up, ucfg, upstreamURL, prepared.body, attemptSpan)
+				if failure != nil {
+					attemptSpan.SetError(failure.Error())
+				} else {
+					attemptSpan.SetAttr("http.response.status_code", res.StatusCode)
+					if res.StatusCode >= 500 {
+						attemptSpan.SetError(http.StatusText(res.StatusCode))
+					}
+				}
+				attemptSpan.End()
+				if r.Context().Err() != nil {
+					if res != nil {
+						res.Body.Close()
+						release()
+					}
+					logCancel("before response")
+					return
+				}
+				reportCircuit(up.Name, res, failure)
+				delay, retry := retryDelay(policy, attempt, res, failure)
+				if !retry || breakers[up.Name].isOpen() {
+					break
+				}
+				reason := ""
+				if failure != nil {
+					reason = failure.Error()
+				} else {
+					reason = fmt.Sprintf("status=%d", res.StatusCode)
+					res.Body.Close()
+					release()
+				}
+				stats.retries.Inc(up.Name)
+				rlog.warn("retry", fmt.Sprintf("#%d [%s] attempt %d/%d failed (%s), retrying in %v",
+					reqID, up.Name, attempt, *policy.MaxAttempts, reason, delay.Round(time.Millisecond)),
+					"upstream", up.Name, "attempt", attempt, "reason", reason, "delay_ms", delay.Milliseconds())
+				if sleep(r.Context(), delay) != nil {
+					logCancel("before response")
+					return
+				}
+			}
+			if failure != nil {
+				rlog.error("error", fmt.Sprintf("#%d [%s] %v", reqID, up.Name, failure), "upstream", up.Name, "status", failure.status, "error", failure.Error())
+				failStatus, failMsg = failure.status, failure.msg
+				continue
+			}
+			if shouldFailover(res.StatusCode) && !last {
+				release()
+				stats.failovers.Inc(up.Name)
+				rlog.warn("fail", fmt.Sprintf("#%d [%s] status=%d, trying next upstream", reqID, up.Name, res.StatusCode), "upstream", up.Name, "status", res.StatusCode)
+				res.Body.Close()
+				continue
+			}
+
+			body := &activityBody{ReadCloser: res.Body}
+			res.Body = body
+			if prepared.translate != nil {
+				if err := prepared.translate(res); err != nil {
+					rlog.error("error", fmt.Sprintf("#%d [%s] cannot translate response: %v", reqID, up.Name, err), "upstream", up.Name, "error", err.Error())
+					failStatus, failMsg = http.StatusBadGateway, fmt.Sprintf("Failed to translate response: %v", err)
+					res.Body.Close()
+					release()
+					continue
+				}
+			}
+
+			resp, upstreamBody = res, body
+			releaseSlot = release
+			record.Upstream, record.UpstreamModel = up.Name, mapped
+			if !shouldFailover(res.StatusCode) {
+				setActive(up.Name)
+			}
+			break
+		}
+		if resp == nil && skipped == len(candidates) {
+			record.Status = statusOverloaded
+			rlog.error("error", fmt.Sprintf("#%d all upstream circuits open", reqID), "status", statusOverloaded)
+			writeError(w, statusOverloaded, "All upstreams are unavailable (circuit open); retry shortly")
+			return
+		}
+		if resp == nil {
+			record.Status = failStatus
+			rlog.info("done", fmt.Sprintf("#%d [%s] %s %v", reqID, streamType, outcome(), time.Since(startTime).Round(time.Millisecond)),
+				resultAttrs(record, 0, time.Since(startTime))...)
+			writeError(w, failStatus, failMsg)
 			return
 		}
 		defer resp.Body.Close()
+		record.Status = resp.StatusCode
+		exchange.SetUpstreamResponse(resp.StatusCode, resp.Header)
+		if id := resp.Header.Get(requestIDHeader); id != "" {
+			w.Header().Set(requestIDHeader, id)
+			resp.Header.Del(requestIDHeader)
+			record.RequestID = id
+			rlog = rlog.with("upstream_request_id", id)
+		}
 
-		logDebug("RES    #%d status=%d", reqID, resp.StatusCode)
+		rlog.debug("res", fmt.Sprintf("#%d status=%d", reqID, resp.StatusCode), "upstream", record.Upstream, "status", resp.StatusCode)
 
 		if isStreaming && resp.StatusCode == http.StatusOK {
 			w.Header().Set("Content-Type", "text/event-stream")
 			w.Header().Set("Cache-Control", "no-cache")
 			w.Header().Set("Connection", "keep-alive")
 			w.WriteHeader(http.StatusOK)
+			stats.streams.Add(1)
+			defer stats.streams.Add(-1)
+			streamSpan := span.Child("stream", tracing.KindInternal)
+			defer streamSpan.End()
 
 			flusher, ok := w.(http.Flusher)
 			if !ok {
-				logInfo("ERROR  #%d flusher not supported", reqID)
+				rlog.error("error", fmt.Sprintf("#%d flusher not supported", reqID))
+				w.Write(anthropic.ErrorEvent("api_error", "Proxy cannot stream responses"))
 				return
 			}
 
 			reader := bufio.NewReader(resp.Body)
 			totalBytes := 0
+			var parser usage.StreamParser
 
+			// The watchdog closes the body when the upstream goes quiet,
+			// which unblocks the read below. Any upstream bytes count, even
+			// keep-alives that translate to nothing.
+			var idleFired atomic.Bool
+			watchdog := time.AfterFunc(idleTimeout, func() {
+				idleFired.Store(true)
+				resp.Body.Close()
+			})
+			defer watchdog.Stop()
+			upstreamBody.onRead = func() { watchdog.Reset(idleTimeout) }
+
+			// Failures after the headers are sent can only be reported
+			// in-band, as an SSE error event.
+			streamError := func(msg string) {
+				streamSpan.SetError(msg)
+				rlog.error("error", fmt.Sprintf("#%d %s", reqID, msg), "upstream", record.Upstream, "error", msg)
+				w.Write(anthropic.ErrorEvent("api_error", msg))
+				flusher.Flush()
+			}
+			finished, sawToken := false, false
 			for {
 				line, err := reader.ReadBytes('\n')
 				if err != nil {
-					if err != io.EOF {
-						logDebug("STREAM #%d read error: %v", reqID, err)
+					switch {
+					case r.Context().Err() != nil:
+					case idleFired.Load():
+						streamError(fmt.Sprintf("Upstream sent nothing for %v", idleTimeout))
+					case isTimeout(err):
+						streamError("Upstream request exceeded the total timeout")
+					case err != io.EOF:
+						streamError(fmt.Sprintf("Upstream stream failed: %v", err))
+					case !finished:
+						streamError("Upstream closed the stream before the message finished")
 					}
 					break
 				}
 				totalBytes += len(line)
+				if bytes.HasPrefix(line, []byte("event: message_stop")) || bytes.HasPrefix(line, []byte("event: error")) {
+					finished = true
+				}
+				if !sawToken && bytes.Contains(line, []byte(`"content_block_delta"`)) {
+					sawToken = true
+					stats.ttft.Observe(time.Since(startTime).Seconds(), stats.model(model), record.Upstream)
+					streamSpan.SetAttr("claude_proxy.time_to_first_token_ms", time.Since(startTime).Milliseconds())
+				}
+				parser.Line(line)
 				if _, writeErr := w.Write(line); writeErr != nil {
-					logDebug("STREAM #%d write error: %v", reqID, writeErr)
+					rlog.debug("stream", fmt.Sprintf("#%d write error: %v", reqID, writeErr), "error", writeErr.Error())
 					break
 				}
 				flusher.Flush()
 			}
-			logInfo("DONE   #%d [%s] %dB %v", reqID, streamType, totalBytes, time.Since(startTime).Round(time.Millisecond))
+			u := parser.Partial()
+			cost := recordSpend(model, record.UpstreamModel, u)
+			record.Usage, record.CostUSD = u, cost
+			if r.Context().Err() != nil {
+				logCancel(fmt.Sprintf("%dB %s $%.4f (partial)", totalBytes, u, cost))
+			} else {
+				rlog.info("done", fmt.Sprintf("#%d [%s] %s %dB %v %s $%.4f", reqID, streamType, outcome(), totalBytes, time.Since(startTime).Round(time.Millisecond), u, cost),
+					resultAttrs(record, int64(totalBytes), time.Since(startTime))...)
+			}
 		} else {
+			if resp.StatusCode >= 400 {
+				data, _ := io.ReadAll(resp.Body)
+				if fixed, changed := normalizeError(resp.StatusCode, data); changed {
+					data = fixed
+					resp.Header.Set("Content-Type", "application/json")
+					resp.Header.Del("Content-Length")
+				}
+				resp.Body = io.NopCloser(bytes.NewReader(data))
+			}
 			for key, values := range resp.Header {
 				for _, value := range values {
 					w.Header().Add(key, value)
 				}
 			}
 			w.WriteHeader(resp.StatusCode)
-			written, _ := io.Copy(w, resp.Body)
-			logInfo("DONE   #%d [%s] %dB %v", reqID, streamType, written, time.Since(startTime).Round(time.Millisecond))
+			var captured bytes.Buffer
+			responseSpan := span.Child("response", tracing.KindInternal)
+			written, _ := io.Copy(w, io.TeeReader(resp.Body, &captured))
+			responseSpan.SetAttr("claude_proxy.bytes", written)
+			responseSpan.End()
+			if r.Context().Err() != nil {
+				logCancel(fmt.Sprintf("%dB", written))
+			} else if u, ok := usage.FromResponse(captured.Bytes()); ok && resp.StatusCode == http.StatusOK {
+				cost := recordSpend(model, record.UpstreamModel, u)
+				record.Usage, record.CostUSD = u, cost
+				rlog.info("done", fmt.Sprintf("#%d [%s] %s %dB %v %s $%.4f", reqID, streamType, outcome(), written, time.Since(startTime).Round(time.Millisecond), u, cost),
+					resultAttrs(record, written, time.Since(startTime))...)
+			} else {
+				rlog.info("done", fmt.Sprintf("#%d [%s] %s %dB %v", reqID, streamType, outcome(), written, time.Since(startTime).Round(time.Millisecond)),
+					resultAttrs(record, written, time.Since(startTime))...)
+			}
 		}
 	}
 
 	handleHealth := func(w http.ResponseWriter, r *http.Request) {
-		token, _, err := config.GetToken(cfg)
+		mu.Lock()
+		active := activeUpstream
+		creds := make(map[string]credentialState, len(credentials))
+		for name, state := range credentials {
+			creds[name] = state
+		}
+		mu.Unlock()
+
+		var upstreamStatus []map[string]interface{}
+		current := map[string]interface{}{}
+		for _, up := range upstreams {
+			// Credentials are only looked up when a request needs them, so
+			// a health poll never mints a token or reads key files.
+			state, checked := creds[up.Name]
+			if !checked && up.AuthType == "apikey" {
+				state, checked = credentialState{ok: up.APIKey != ""}, true
+			}
+			entry := map[string]interface{}{
+				"name":      up.Name,
+				"target":    up.Target,
+				"auth_type": up.AuthType,
+				"cf_access": up.CfAccess,
+				"has_token": nil,
+				"active":    up.Name == active,
+			}
+			if checked {
+				entry["has_token"] = state.ok
+				if state.err != "" {
+					entry["auth_error"] = state.err
+				}
+				if !state.at.IsZero() {
+					entry["auth_checked"] = state.at.UTC().Format(time.RFC3339)
+				}
+			}
+			entry["circuit"] = breakers[up.Name].stats(time.Now())
+			if lim := limiters[up.Name]; lim != nil {
+				entry["limits"] = up.Limits
+				entry["queue"] = lim.stats()
+			}
+			if up.Name == active {
+				current = entry
+			}
+			upstreamStatus = append(upstreamStatus, entry)
+		}
+
+		snapshot := usageStats.Snapshot()
+		totalCost := 0.0
+		for _, t := range snapshot {
+			totalCost += t.CostUSD
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"status":    "ok",
-			"target":    cfg.Target,
-			"auth_type": cfg.AuthType,
-			"cf_access": cfg.CfAccess,
-			"has_token": err == nil && token != "",
+			"active":    active,
+			"target":    current["target"],
+			"auth_type": current["auth_type"],
+			"cf_access": current["cf_access"],
+			"has_token": current["has_token"],
+			"upstreams": upstreamStatus,
+			"usage":     snapshot,
+			"cost_usd":  totalCost,
+			"budgets":   budgets.Snapshot(time.Now()),
 		})
 	}
 
-	http.HandleFunc("/health", handleHealth)
-	http.HandleFunc("/", handleProxy)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", handleHealth)
+	mux.Handle("/metrics", stats.registry)
+	mux.HandleFunc("/", handleProxy)
 
-	addr := fmt.Sprintf("%s:%d", bindAddr, port)
-	fmt.Printf("Proxy: http://%s -> %s\n", addr, cfg.Target)
-	fmt.Printf("Auth: %s, CF-Access: %v\n", cfg.AuthType, cfg.CfAccess)
-	if verbose {
-		fmt.Println("Verbose: on")
+	return &Handler{
+		mux:       mux,
+		logger:    logger,
+		upstreams: upstreams,
+		names:     names,
+		budgets:   budgets,
+		tracer:    tracer,
+		ledger:    ledger,
+	}, nil
+}
+
+// loadBudgets seeds budget spend from the ledger so caps hold across
+// restarts.
+func loadBudgets(cfg config.Config, prices usage.PriceTable, usageFile string, logger eventLog) *usage.Budgets {
+	now := time.Now()
+	var records []usage.Record
+	if len(cfg.Budgets) > 0 {
+		var err error
+		records, err = usage.ReadLedger(usageFile, usage.Since(cfg.Budgets, now))
+		if err != nil {
+			logger.warn("budget", fmt.Sprintf("Failed to read usage ledger for budgets: %v", err), "error", err.Error())
+		}
 	}
-	fmt.Println()
+	return usage.NewBudgets(cfg.Budgets, records, prices, now)
+}
 
-	if err := http.ListenAndServe(addr, nil); err != nil {
-		log.Fatalf("Server failed: %v", err)
+// authorize adds the upstream's auth headers. Bedrock requests are signed,
+// so body must be the exact payload being sent.
+func authorize(req *http.Request, cfg config.Config, token, authType string, body []byte) error {
+	if authType == "bedrock" {
+		creds, err := config.AWSCredentials(cfg)
+		if err != nil {
+			return err
+		}
+		bedrock.Sign(req, body, creds, config.AWSRegion(cfg), time.Now())
+		return nil
+	}
+	if authType == "vertex" {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+
+	if cfg.CfAccess {
+		req.Header.Set("cf-access-token", token)
+		if cfg.CfClientID != "" && cfg.CfClientSecret != "" {
+			req.Header.Set("CF-Access-Client-Id", cfg.CfClientID)
+			req.Header.Set("CF-Access-Client-Secret", cfg.CfClientSecret)
+		}
+	} else if authType == "apikey" && cfg.Protocol != "openai" {
+		req.Header.Set("x-api-key", token)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+// credentialState is the outcome of an upstream's latest credential lookup,
+// reported by /health.
+type credentialState struct {
+	ok  bool
+	err string
+	at  time.Time
+}
+
+func newCredentialState(token string, err error) credentialState {
+	if err != nil {
+		return credentialState{err: err.Error(), at: time.Now()}
 	}
+	return credentialState{ok: token != "", at: time.Now()}
+}
+
+// statusClientClosed is recorded for requests the client abandoned before
+// the response finished (nginx's "client closed request").
+const statusClientClosed = 499
+
+// isTimeout reports whether err is a network timeout or an expired request
+// deadline.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// shouldFailover reports whether a response status means the next upstream
+// should be tried: any 5xx, including Anthropic's 529 overloaded.
+func shouldFailover(status int) bool {
+	return status >= 500
 }
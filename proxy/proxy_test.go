@@ -0,0 +1,1230 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/schachte/claudecode-opencode-proxy/anthropic"
+	"github.com/schachte/claudecode-opencode-proxy/bedrock"
+	"github.com/schachte/claudecode-opencode-proxy/config"
+	"github.com/schachte/claudecode-opencode-proxy/mockupstream"
+	"github.com/schachte/claudecode-opencode-proxy/usage"
+)
+
+// received is a request as it reached the upstream.
+type received struct {
+	Path   string
+	Header http.Header
+	Body   map[string]interface{}
+}
+
+// upstream records each request and hands it to a mockupstream.Server.
+// Bedrock and Vertex paths carry the model instead of /v1/messages, so they
+// are answered as /v1/messages too.
+type upstream struct {
+	*httptest.Server
+	mock *mockupstream.Server
+
+	mu   sync.Mutex
+	reqs []received
+}
+
+func newUpstream(t *testing.T, mock *mockupstream.Server) *upstream {
+	u := &upstream{mock: mock}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rec := received{Path: r.URL.Path, Header: r.Header.Clone()}
+		json.Unmarshal(body, &rec.Body)
+		u.mu.Lock()
+		u.reqs = append(u.reqs, rec)
+		u.mu.Unlock()
+
+		if r.Method == http.MethodPost && !strings.HasPrefix(r.URL.Path, "/v1/messages") {
+			r.URL.Path = "/v1/messages"
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		mock.ServeHTTP(w, r)
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+func (u *upstream) received() []received {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]received(nil), u.reqs...)
+}
+
+func (u *upstream) last(t *testing.T) received {
+	t.Helper()
+	reqs := u.received()
+	if len(reqs) == 0 {
+		t.Fatal("upstream received no requests")
+	}
+	return reqs[len(reqs)-1]
+}
+
+// syncBuffer is a ledger the test can read while the proxy writes to it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+type testProxy struct {
+	*httptest.Server
+	handler *Handler
+	ledger  *syncBuffer
+}
+
+func newProxy(t *testing.T, cfg config.Config, opts Options) *testProxy {
+	t.Helper()
+	ledger := &syncBuffer{}
+	if opts.LogOutput == nil {
+		opts.Logs = LogOptions{Quiet: true}
+	}
+	opts.UsageFile = filepath.Join(t.TempDir(), "usage.jsonl")
+	opts.Ledger = ledger
+	h, err := New(cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(h)
+	t.Cleanup(func() {
+		srv.Close()
+		if err := h.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	return &testProxy{Server: srv, handler: h, ledger: ledger}
+}
+
+// records returns the ledger. Records are written once the handler returns,
+// which can be just after the client has read the whole response.
+func (p *testProxy) records(t *testing.T, want int) []usage.Record {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		p.ledger.mu.Lock()
+		data := append([]byte(nil), p.ledger.buf.Bytes()...)
+		p.ledger.mu.Unlock()
+		var records []usage.Record
+		for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+			if len(line) == 0 {
+				continue
+			}
+			var rec usage.Record
+			if err := json.Unmarshal(line, &rec); err != nil {
+				t.Fatalf("bad ledger line %s: %v", line, err)
+			}
+			records = append(records, rec)
+		}
+		if len(records) >= want || time.Now().After(deadline) {
+			if len(records) != want {
+				t.Fatalf("ledger has %d records, want %d", len(records), want)
+			}
+			return records
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func testConfig(target string) config.Config {
+	attempts := 1
+	return config.Config{
+		Target:         target,
+		AuthType:       "apikey",
+		APIKey:         "sk-upstream",
+		ForwardHeaders: config.DefaultConfig().ForwardHeaders,
+		Rules:          config.DefaultRules(),
+		Retry:          &config.RetryConfig{MaxAttempts: &attempts, InitialBackoffMs: 1, MaxBackoffMs: 5},
+	}
+}
+
+func withAttempts(cfg config.Config, n int) config.Config {
+	retry := *cfg.Retry
+	retry.MaxAttempts = &n
+	cfg.Retry = &retry
+	return cfg
+}
+
+func post(t *testing.T, url, body string, header map[string]string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url+"/v1/messages", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func message(model string, stream bool) string {
+	return fmt.Sprintf(`{"model":%q,"stream":%v,"max_tokens":16,"messages":[{"role":"user","content":"hello there"}]}`, model, stream)
+}
+
+// errorType returns the type of an Anthropic error body.
+func errorType(t *testing.T, body string) string {
+	t.Helper()
+	var e struct {
+		Type  string `json:"type"`
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(body), &e); err != nil || e.Type != "error" {
+		t.Fatalf("not an Anthropic error: %s", body)
+	}
+	return e.Error.Type
+}
+
+func TestProxyRewritesRequest(t *testing.T) {
+	up := newUpstream(t, &mockupstream.Server{})
+	cfg := testConfig(up.URL)
+	cfg.Rules = append(cfg.Rules,
+		config.RewriteRule{Name: "cap-opus", Match: config.RuleMatch{Model: "claude-opus-*"}, Action: "set", Field: "max_tokens", Value: 64.0},
+		config.RewriteRule{Name: "haiku-only", Match: config.RuleMatch{Model: "claude-haiku-*"}, Action: "set", Field: "max_tokens", Value: 1.0},
+		config.RewriteRule{Name: "other-upstream", Match: config.RuleMatch{Upstream: "other"}, Action: "set", Field: "metadata.user_id", Value: "x"},
+		config.RewriteRule{Name: "rename", Action: "rename", Field: "temperature", To: "top_p"},
+	)
+	cfg.Models = map[string]string{"claude-opus-*": "opus-upstream"}
+	p := newProxy(t, cfg, Options{})
+
+	resp := post(t, p.URL, `{"model":"claude-opus-4-1","max_tokens":1024,"temperature":0.5,
+		"context_management":{"edits":[]},"mcp_servers":[],"messages":[{"role":"user","content":"hi"}]}`, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d: %s", resp.StatusCode, readBody(t, resp))
+	}
+	got := up.last(t).Body
+	want := map[string]interface{}{
+		"model":      "opus-upstream",
+		"max_tokens": 64.0,
+		"top_p":      0.5,
+		"messages":   []interface{}{map[string]interface{}{"role": "user", "content": "hi"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("upstream body =\n%v\nwant\n%v", got, want)
+	}
+
+	rec := p.records(t, 1)[0]
+	if rec.Model != "claude-opus-4-1" || rec.UpstreamModel != "opus-upstream" || rec.Upstream != "default" {
+		t.Errorf("ledger record = %+v", rec)
+	}
+}
+
+func TestProxyRulesPerUpstream(t *testing.T) {
+	first := newUpstream(t, &mockupstream.Server{Faults: []*mockupstream.Fault{{Status: 500}}})
+	second := newUpstream(t, &mockupstream.Server{})
+	cfg := testConfig("")
+	cfg.Upstreams = []config.Upstream{
+		{Name: "first", Target: first.URL, AuthType: "apikey", APIKey: "key-1"},
+		{Name: "second", Target: second.URL, AuthType: "apikey", APIKey: "key-2", Models: map[string]string{"claude-*": "second-model"}},
+	}
+	cfg.Rules = append(cfg.Rules, config.RewriteRule{Match: config.RuleMatch{Upstream: "second"}, Action: "set", Field: "metadata.user_id", Value: "u1"})
+	p := newProxy(t, cfg, Options{})
+
+	resp := post(t, p.URL, message("claude-sonnet-4-5", false), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d: %s", resp.StatusCode, readBody(t, resp))
+	}
+	a, b := first.last(t), second.last(t)
+	if _, ok := a.Body["metadata"]; ok || a.Body["model"] != "claude-sonnet-4-5" {
+		t.Errorf("first upstream body = %v", a.Body)
+	}
+	if !reflect.DeepEqual(b.Body["metadata"], map[string]interface{}{"user_id": "u1"}) || b.Body["model"] != "second-model" {
+		t.Errorf("second upstream body = %v", b.Body)
+	}
+	if a.Header.Get("x-api-key") != "key-1" || b.Header.Get("x-api-key") != "key-2" {
+		t.Errorf("x-api-key = %q, %q", a.Header.Get("x-api-key"), b.Header.Get("x-api-key"))
+	}
+}
+
+func TestProxyForwardsHeaders(t *testing.T) {
+	up := newUpstream(t, &mockupstream.Server{})
+	cfg := testConfig(up.URL)
+	cfg.DropHeaders = []string{"x-stainless-retry-count"}
+	p := newProxy(t, cfg, Options{})
+
+	resp := post(t, p.URL, message("claude-sonnet-4-5", false), map[string]string{
+		"anthropic-beta":          "beta-1",
+		"anthropic-version":       "2023-01-01",
+		"x-stainless-os":          "Linux",
+		"x-stainless-retry-count": "2",
+		"x-app":                   "cli",
+		"user-agent":              "claude-cli/1.0",
+		"x-unlisted":              "v",
+		"authorization":           "Bearer client-token",
+		"x-api-key":               "client-key",
+		"cookie":                  "session=1",
+		usage.ProjectHeader:       "/work/project",
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d: %s", resp.StatusCode, readBody(t, resp))
+	}
+	header := up.last(t).Header
+	for name, want := range map[string]string{
+		"anthropic-beta":          "beta-1",
+		"anthropic-version":       "2023-01-01",
+		"x-stainless-os":          "Linux",
+		"x-app":                   "cli",
+		"user-agent":              "claude-cli/1.0",
+		"x-api-key":               "sk-upstream",
+		"x-stainless-retry-count": "",
+		"x-unlisted":              "",
+		"authorization":           "",
+		"cookie":                  "",
+		usage.ProjectHeader:       "",
+	} {
+		if got := header.Get(name); got != want {
+			t.Errorf("upstream %s = %q, want %q", name, got, want)
+		}
+	}
+	if rec := p.records(t, 1)[0]; rec.Project != "/work/project" {
+		t.Errorf("ledger project = %q", rec.Project)
+	}
+}
+
+func TestProxyDefaultsAnthropicVersion(t *testing.T) {
+	up := newUpstream(t, &mockupstream.Server{})
+	p := newProxy(t, testConfig(up.URL), Options{})
+	post(t, p.URL, message("claude-sonnet-4-5", false), nil)
+	if got := up.last(t).Header.Get("anthropic-version"); got != "2023-06-01" {
+		t.Errorf("anthropic-version = %q", got)
+	}
+}
+
+// writeServiceAccount writes a Vertex service-account key.
+func writeServiceAccount(t *testing.T) string {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "proxy@example.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	})
+	path := filepath.Join(t.TempDir(), "sa.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeAuthFile writes an opencode auth file holding token for loginURL.
+func writeAuthFile(t *testing.T, loginURL, token string) string {
+	t.Helper()
+	data, _ := json.Marshal(map[string]map[string]string{loginURL: {"token": token}})
+	path := filepath.Join(t.TempDir(), "auth.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestProxyAuth(t *testing.T) {
+	const loginURL = "https://login.example"
+	authFile := writeAuthFile(t, loginURL, "oc-token")
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token":"vertex-token","expires_in":3600,"token_type":"Bearer"}`)
+	}))
+	defer tokenServer.Close()
+	serviceAccount := writeServiceAccount(t)
+
+	tests := []struct {
+		name   string
+		config func(cfg config.Config) config.Config
+		path   string
+		header map[string]string // "" means the header must be absent
+		check  func(t *testing.T, rec received)
+	}{
+		{
+			name:   "apikey",
+			config: func(cfg config.Config) config.Config { return cfg },
+			path:   "/v1/messages",
+			header: map[string]string{"x-api-key": "sk-upstream", "authorization": "", "cf-access-token": ""},
+		},
+		{
+			name: "apikey with cf access",
+			config: func(cfg config.Config) config.Config {
+				cfg.CfAccess, cfg.CfClientID, cfg.CfClientSecret = true, "client-id", "client-secret"
+				return cfg
+			},
+			path: "/v1/messages",
+			header: map[string]string{"cf-access-token": "sk-upstream", "cf-access-client-id": "client-id",
+				"cf-access-client-secret": "client-secret", "x-api-key": "", "authorization": ""},
+		},
+		{
+			name: "opencode",
+			config: func(cfg config.Config) config.Config {
+				cfg.AuthType, cfg.APIKey, cfg.LoginURL = "opencode", authFile, loginURL
+				return cfg
+			},
+			path:   "/v1/messages",
+			header: map[string]string{"authorization": "Bearer oc-token", "cf-access-token": "", "x-api-key": ""},
+		},
+		{
+			name: "opencode with cf access",
+			config: func(cfg config.Config) config.Config {
+				cfg.AuthType, cfg.APIKey, cfg.LoginURL, cfg.CfAccess = "opencode", authFile, loginURL, true
+				return cfg
+			},
+			path:   "/v1/messages",
+			header: map[string]string{"cf-access-token": "oc-token", "cf-access-client-id": "", "authorization": ""},
+		},
+		{
+			name: "bedrock",
+			config: func(cfg config.Config) config.Config {
+				cfg.AuthType = "bedrock"
+				cfg.AWS = &config.AWSConfig{Region: "us-west-2", AccessKeyID: "AKIDTEST", SecretAccessKey: "secret"}
+				return cfg
+			},
+			path:   "/model/claude-test/invoke",
+			header: map[string]string{"x-api-key": ""},
+			check: func(t *testing.T, rec received) {
+				auth := rec.Header.Get("Authorization")
+				if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDTEST/") || !strings.Contains(auth, "/us-west-2/bedrock/aws4_request") {
+					t.Errorf("Authorization = %q", auth)
+				}
+				if rec.Header.Get("X-Amz-Date") == "" {
+					t.Error("X-Amz-Date not set")
+				}
+				if rec.Body["anthropic_version"] != bedrock.AnthropicVersion || rec.Body["model"] != nil {
+					t.Errorf("bedrock body = %v", rec.Body)
+				}
+			},
+		},
+		{
+			name: "vertex",
+			config: func(cfg config.Config) config.Config {
+				cfg.AuthType = "vertex"
+				cfg.Vertex = &config.VertexConfig{ProjectID: "proj", Region: "us-east5", Credentials: serviceAccount, TokenURL: tokenServer.URL}
+				return cfg
+			},
+			path:   "/v1/projects/proj/locations/us-east5/publishers/anthropic/models/claude-test:rawPredict",
+			header: map[string]string{"authorization": "Bearer vertex-token", "x-api-key": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up := newUpstream(t, &mockupstream.Server{})
+			p := newProxy(t, tt.config(testConfig(up.URL)), Options{})
+
+			resp := post(t, p.URL, message("claude-test", false), map[string]string{"authorization": "Bearer client-token"})
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d: %s", resp.StatusCode, readBody(t, resp))
+			}
+			rec := up.last(t)
+			if rec.Path != tt.path {
+				t.Errorf("path = %s, want %s", rec.Path, tt.path)
+			}
+			for name, want := range tt.header {
+				if got := rec.Header.Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+			if tt.check != nil {
+				tt.check(t, rec)
+			}
+		})
+	}
+}
+
+func TestProxyAuthFailure(t *testing.T) {
+	up := newUpstream(t, &mockupstream.Server{})
+	cfg := testConfig(up.URL)
+	cfg.AuthType, cfg.APIKey, cfg.LoginURL = "opencode", filepath.Join(t.TempDir(), "missing.json"), "https://login.example"
+	p := newProxy(t, cfg, Options{})
+
+	resp := post(t, p.URL, message("claude-test", false), nil)
+	body := readBody(t, resp)
+	if resp.StatusCode != http.StatusUnauthorized || errorType(t, body) != "authentication_error" {
+		t.Errorf("status = %d, body = %s", resp.StatusCode, body)
+	}
+	if len(up.received()) != 0 {
+		t.Error("request reached the upstream without credentials")
+	}
+}
+
+// readEvent reads one SSE event, up to its blank line.
+func readEvent(r *bufio.Reader) (string, error) {
+	var event strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		event.WriteString(line)
+		if err != nil {
+			return event.String(), err
+		}
+		if line == "\n" {
+			return event.String(), nil
+		}
+	}
+}
+
+func TestProxyFlushesStreamEvents(t *testing.T) {
+	release := make(chan struct{})
+	var once sync.Once
+	finish := func() { once.Do(func() { close(release) }) }
+	defer finish()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.Write(anthropic.Event("message_start", map[string]interface{}{"type": "message_start",
+			"message": map[string]interface{}{"id": "msg_1", "usage": map[string]interface{}{"input_tokens": 3}}}))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		w.Write(anthropic.Event("message_delta", map[string]interface{}{"type": "message_delta",
+			"delta": map[string]interface{}{"stop_reason": "end_turn"}, "usage": map[string]interface{}{"output_tokens": 2}}))
+		w.Write(anthropic.Event("message_stop", map[string]interface{}{"type": "message_stop"}))
+	}))
+	defer up.Close()
+	p := newProxy(t, testConfig(up.URL), Options{})
+
+	resp := post(t, p.URL, message("claude-sonnet-4-5", true), nil)
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	reader := bufio.NewReader(resp.Body)
+	first := make(chan string, 1)
+	go func() {
+		event, _ := readEvent(reader)
+		first <- event
+	}()
+	// The upstream holds the rest of the stream until the first event has
+	// made it through the proxy.
+	select {
+	case event := <-first:
+		if !strings.HasPrefix(event, "event: message_start\n") {
+			t.Fatalf("first event = %q", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("first event was not flushed to the client")
+	}
+	finish()
+	rest, _ := io.ReadAll(reader)
+	if !strings.Contains(string(rest), "event: message_stop") {
+		t.Errorf("rest of stream = %s", rest)
+	}
+	rec := p.records(t, 1)[0]
+	if rec.InputTokens != 3 || rec.OutputTokens != 2 || !rec.Stream {
+		t.Errorf("ledger record = %+v", rec)
+	}
+}
+
+func TestProxyStreamsFromMock(t *testing.T) {
+	up := newUpstream(t, &mockupstream.Server{Text: "hello from the mock"})
+	p := newProxy(t, testConfig(up.URL), Options{})
+
+	resp := post(t, p.URL, message("claude-sonnet-4-5", true), nil)
+	body := readBody(t, resp)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d: %s", resp.StatusCode, body)
+	}
+	if id := resp.Header.Get("request-id"); !strings.HasPrefix(id, "req_mock_") {
+		t.Errorf("request-id = %q, want the upstream's", id)
+	}
+	var types []string
+	var text strings.Builder
+	for _, line := range strings.Split(body, "\n") {
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			types = append(types, name)
+		}
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			var event struct {
+				Delta struct {
+					Text string `json:"text"`
+				} `json:"delta"`
+			}
+			json.Unmarshal([]byte(data), &event)
+			text.WriteString(event.Delta.Text)
+		}
+	}
+	if types[0] != "message_start" || types[len(types)-1] != "message_stop" {
+		t.Errorf("event types = %v", types)
+	}
+	if text.String() != "hello from the mock" {
+		t.Errorf("streamed text = %q", text.String())
+	}
+}
+
+func TestProxyCopiesResponseHeaders(t *testing.T) {
+	const reply = `{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"text","text":"ok"}],"usage":{"input_tokens":3,"output_tokens":1}}`
+	up := newUpstream(t, &mockupstream.Server{Fixtures: []mockupstream.Fixture{{
+		Name: "headers",
+		Response: &mockupstream.Response{
+			Status: http.StatusOK,
+			Header: http.Header{
+				"Content-Type":                           {"application/json"},
+				"Anthropic-Ratelimit-Requests-Remaining": {"42"},
+				"X-Upstream":                             {"a", "b"},
+			},
+			Body: []byte(reply),
+		},
+	}}})
+	p := newProxy(t, testConfig(up.URL), Options{})
+
+	resp := post(t, p.URL, message("claude-sonnet-4-5", false), nil)
+	if body := readBody(t, resp); body != reply {
+		t.Errorf("body = %s", body)
+	}
+	if got := resp.Header.Get("Anthropic-Ratelimit-Requests-Remaining"); got != "42" {
+		t.Errorf("ratelimit header = %q", got)
+	}
+	if got := resp.Header.Values("X-Upstream"); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("X-Upstream = %v", got)
+	}
+	if got := resp.Header.Get("request-id"); !strings.HasPrefix(got, "req_mock_") {
+		t.Errorf("request-id = %q", got)
+	}
+	rec := p.records(t, 1)[0]
+	if rec.Status != http.StatusOK || rec.InputTokens != 3 || rec.OutputTokens != 1 || rec.RequestID != resp.Header.Get("request-id") {
+		t.Errorf("ledger record = %+v", rec)
+	}
+}
+
+func TestProxyErrors(t *testing.T) {
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name     string
+		mock     *mockupstream.Server
+		target   string // overrides the mock
+		status   int
+		errType  string
+		contains string
+	}{
+		{
+			name: "html error page",
+			mock: &mockupstream.Server{Fixtures: []mockupstream.Fixture{{Response: &mockupstream.Response{
+				Status: http.StatusForbidden,
+				Header: http.Header{"Content-Type": {"text/html"}},
+				Body:   []byte("<html>Forbidden by gateway</html>"),
+			}}}},
+			status:   http.StatusForbidden,
+			errType:  "permission_error",
+			contains: "Forbidden by gateway",
+		},
+		{
+			name:    "upstream rate limit",
+			mock:    &mockupstream.Server{Faults: []*mockupstream.Fault{{Status: http.StatusTooManyRequests}}},
+			status:  http.StatusTooManyRequests,
+			errType: "rate_limit_error",
+		},
+		{
+			name:     "unreachable upstream",
+			target:   closed.URL,
+			status:   http.StatusBadGateway,
+			errType:  "api_error",
+			contains: "Upstream request failed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := tt.target
+			if target == "" {
+				target = newUpstream(t, tt.mock).URL
+			}
+			p := newProxy(t, testConfig(target), Options{})
+
+			resp := post(t, p.URL, message("claude-sonnet-4-5", false), nil)
+			body := readBody(t, resp)
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d: %s", resp.StatusCode, tt.status, body)
+			}
+			if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			if got := errorType(t, body); got != tt.errType {
+				t.Errorf("error type = %q, want %q", got, tt.errType)
+			}
+			if !strings.Contains(body, tt.contains) {
+				t.Errorf("body = %s, want it to mention %q", body, tt.contains)
+			}
+			if rec := p.records(t, 1)[0]; rec.Status != tt.status {
+				t.Errorf("ledger status = %d", rec.Status)
+			}
+		})
+	}
+}
+
+func TestProxyRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts int
+		status   int
+		requests int
+	}{
+		{"retried until success", 3, http.StatusOK, 3},
+		{"retries exhausted", 2, statusOverloaded, 2},
+		{"zero disables retries", 0, statusOverloaded, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockupstream.Server{Faults: []*mockupstream.Fault{{Status: statusOverloaded, First: 2}}}
+			up := newUpstream(t, mock)
+			p := newProxy(t, withAttempts(testConfig(up.URL), tt.attempts), Options{})
+
+			resp := post(t, p.URL, message("claude-sonnet-4-5", false), nil)
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d: %s", resp.StatusCode, tt.status, readBody(t, resp))
+			}
+			if got := mock.Requests(); got != tt.requests {
+				t.Errorf("upstream requests = %d, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestProxyFailover(t *testing.T) {
+	broken := &mockupstream.Server{Faults: []*mockupstream.Fault{{Status: http.StatusInternalServerError}}}
+	healthy := &mockupstream.Server{}
+	a, b := newUpstream(t, broken), newUpstream(t, healthy)
+	cfg := testConfig("")
+	cfg.Upstreams = []config.Upstream{
+		{Name: "primary", Target: a.URL, AuthType: "apikey", APIKey: "k"},
+		{Name: "backup", Target: b.URL, AuthType: "apikey", APIKey: "k"},
+	}
+	p := newProxy(t, cfg, Options{})
+
+	resp := post(t, p.URL, message("claude-sonnet-4-5", true), nil)
+	body := readBody(t, resp)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "event: message_stop") {
+		t.Fatalf("status = %d: %s", resp.StatusCode, body)
+	}
+	if broken.Requests() != 1 || healthy.Requests() != 1 {
+		t.Errorf("requests = %d, %d", broken.Requests(), healthy.Requests())
+	}
+	if rec := p.records(t, 1)[0]; rec.Upstream != "backup" {
+		t.Errorf("ledger upstream = %q", rec.Upstream)
+	}
+
+	// With every upstream failing, the last one's error reaches the client.
+	cfg.Upstreams[1].Target = a.URL
+	p = newProxy(t, cfg, Options{})
+	resp = post(t, p.URL, message("claude-sonnet-4-5", false), nil)
+	if body := readBody(t, resp); resp.StatusCode != http.StatusInternalServerError || errorType(t, body) != "api_error" {
+		t.Errorf("status = %d: %s", resp.StatusCode, body)
+	}
+}
+
+func TestProxyCircuitOpen(t *testing.T) {
+	mock := &mockupstream.Server{Faults: []*mockupstream.Fault{{Status: http.StatusInternalServerError}}}
+	up := newUpstream(t, mock)
+	cfg := testConfig(up.URL)
+	cfg.Breaker = &config.BreakerConfig{FailureThreshold: 2, CooldownSeconds: 60}
+	p := newProxy(t, cfg, Options{})
+
+	for i := 0; i < 2; i++ {
+		post(t, p.URL, message("claude-sonnet-4-5", false), nil)
+	}
+	resp := post(t, p.URL, message("claude-sonnet-4-5", false), nil)
+	if body := readBody(t, resp); resp.StatusCode != statusOverloaded || errorType(t, body) != "overloaded_error" {
+		t.Errorf("status = %d: %s", resp.StatusCode, body)
+	}
+	if got := mock.Requests(); got != 2 {
+		t.Errorf("upstream requests = %d, want 2", got)
+	}
+}
+
+func TestProxyBudget(t *testing.T) {
+	mock := &mockupstream.Server{}
+	up := newUpstream(t, mock)
+	cfg := testConfig(up.URL)
+	cfg.Budgets = []usage.Budget{{Period: "daily", Model: "claude-opus-*", Hard: 1}}
+	now := time.Now()
+	spent := []usage.Record{{Time: now, Model: "claude-opus-4-1", CostUSD: 2}}
+	p := newProxy(t, cfg, Options{Budgets: usage.NewBudgets(cfg.Budgets, spent, usage.Prices(nil), now)})
+
+	resp := post(t, p.URL, message("claude-opus-4-1", false), nil)
+	if body := readBody(t, resp); resp.StatusCode != http.StatusTooManyRequests || errorType(t, body) != "rate_limit_error" {
+		t.Errorf("status = %d: %s", resp.StatusCode, body)
+	}
+	if resp := post(t, p.URL, message("claude-sonnet-4-5", false), nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("unbudgeted model: status = %d", resp.StatusCode)
+	}
+	if got := mock.Requests(); got != 1 {
+		t.Errorf("upstream requests = %d, want 1", got)
+	}
+	records := p.records(t, 2)
+	if rec := records[0]; rec.Status != http.StatusTooManyRequests || rec.Budget != "daily claude-opus-*" || rec.Model != "claude-opus-4-1" {
+		t.Errorf("rejection record = %+v", rec)
+	}
+	if rec := records[1]; rec.Status != http.StatusOK || rec.Budget != "" {
+		t.Errorf("second record = %+v", rec)
+	}
+}
+
+func TestProxyOpenAITruncatedStream(t *testing.T) {
+	auth := make(chan string, 1)
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		auth <- r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, `data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{"role":"assistant","content":"Hel"}}]}`+"\n\n")
+		// The connection ends without a finish_reason or [DONE].
+	}))
+	defer up.Close()
+	cfg := testConfig(up.URL)
+	cfg.Protocol = "openai"
+	p := newProxy(t, cfg, Options{})
+
+	resp := post(t, p.URL, message("gpt-test", true), nil)
+	body := readBody(t, resp)
+	if got := <-auth; got != "Bearer sk-upstream" {
+		t.Errorf("Authorization = %q", got)
+	}
+	if !strings.Contains(body, `"text":"Hel"`) {
+		t.Errorf("stream lost the text received: %s", body)
+	}
+	if strings.Contains(body, "message_stop") || !strings.Contains(body, "event: error") {
+		t.Errorf("truncated stream not reported as an error:\n%s", body)
+	}
+}
+
+func TestProxyKeepAlivesHoldOffIdleTimeout(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, `data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{"role":"assistant"}}]}`+"\n\n")
+		w.(http.Flusher).Flush()
+		// Neither comments nor role-only chunks translate to any output,
+		// but they show the upstream is alive for longer than idle.
+		for i := 0; i < 6; i++ {
+			time.Sleep(300 * time.Millisecond)
+			io.WriteString(w, ": keep-alive\n\n")
+			w.(http.Flusher).Flush()
+		}
+		io.WriteString(w, `data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{"content":"done"},"finish_reason":"stop"}]}`+"\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	}))
+	defer up.Close()
+	cfg := testConfig(up.URL)
+	cfg.Protocol = "openai"
+	cfg.Timeouts = &config.TimeoutConfig{IdleSeconds: 1}
+	p := newProxy(t, cfg, Options{})
+
+	body := readBody(t, post(t, p.URL, message("gpt-test", true), nil))
+	if strings.Contains(body, "event: error") || !strings.Contains(body, "event: message_stop") {
+		t.Errorf("stream with keep-alives was cut off:\n%s", body)
+	}
+}
+
+func TestProxyIdleTimeout(t *testing.T) {
+	// The mock sends message_start, then stalls for longer than idle.
+	mock := &mockupstream.Server{ChunkDelay: 3 * time.Second}
+	up := newUpstream(t, mock)
+	cfg := testConfig(up.URL)
+	cfg.Timeouts = &config.TimeoutConfig{IdleSeconds: 1}
+	p := newProxy(t, cfg, Options{})
+
+	start := time.Now()
+	body := readBody(t, post(t, p.URL, message("claude-sonnet-4-5", true), nil))
+	elapsed := time.Since(start)
+	if !strings.HasPrefix(body, "event: message_start") {
+		t.Errorf("stream did not start:\n%s", body)
+	}
+	want := string(anthropic.ErrorEvent("api_error", "Upstream sent nothing for 1s"))
+	if !strings.HasSuffix(body, want) {
+		t.Errorf("stream did not end with the idle error:\n%s", body)
+	}
+	if elapsed < time.Second || elapsed > 2500*time.Millisecond {
+		t.Errorf("idle error after %v, want about 1s", elapsed)
+	}
+}
+
+func TestProxyClientCancel(t *testing.T) {
+	upstreamDone := make(chan struct{})
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(upstreamDone)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write(anthropic.Event("message_start", map[string]interface{}{"type": "message_start",
+			"message": map[string]interface{}{"id": "msg_1", "usage": map[string]interface{}{"input_tokens": 7}}}))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer up.Close()
+	logs := &syncBuffer{}
+	p := newProxy(t, testConfig(up.URL), Options{LogOutput: logs})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, p.URL+"/v1/messages", strings.NewReader(message("claude-sonnet-4-5", true)))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if event, _ := readEvent(bufio.NewReader(resp.Body)); !strings.HasPrefix(event, "event: message_start") {
+		t.Fatalf("first event = %q", event)
+	}
+	cancel()
+
+	select {
+	case <-upstreamDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client disconnect did not abort the upstream request")
+	}
+	rec := p.records(t, 1)[0]
+	if rec.Status != statusClientClosed || !rec.Canceled || rec.InputTokens != 7 {
+		t.Errorf("ledger record = %+v", rec)
+	}
+	if !regexp.MustCompile(`\[[0-9:]+\] CANCEL +#1 \[stream\] status=499 `).MatchString(logs.String()) {
+		t.Errorf("no CANCEL line in the log:\n%s", logs.String())
+	}
+}
+
+func TestProxyConcurrentRequests(t *testing.T) {
+	mock := &mockupstream.Server{}
+	up := newUpstream(t, mock)
+	cfg := testConfig(up.URL)
+	cfg.Models = map[string]string{"claude-haiku-*": "haiku-upstream"}
+	p := newProxy(t, cfg, Options{})
+
+	const n = 200
+	models := []string{"claude-sonnet-4-5", "claude-haiku-4-5", "claude-opus-4-1", "unknown-model"}
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := message(models[i%len(models)], i%2 == 0)
+			resp, err := http.Post(p.URL+"/v1/messages", "application/json", strings.NewReader(body))
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer resp.Body.Close()
+			io.Copy(io.Discard, resp.Body)
+			if resp.StatusCode != http.StatusOK {
+				errs <- fmt.Errorf("request %d: status %d", i, resp.StatusCode)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if got := mock.Requests(); got != n {
+		t.Errorf("upstream requests = %d, want %d", got, n)
+	}
+	p.records(t, n)
+
+	resp, err := http.Get(p.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := readBody(t, resp)
+	for _, want := range []string{
+		`claude_proxy_requests_total{model="claude-haiku-4*",upstream="default",status="200"} 50`,
+		`claude_proxy_requests_total{model="other",upstream="default",status="200"} 50`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
+
+func TestProxyMetricsLabelsBounded(t *testing.T) {
+	up := newUpstream(t, &mockupstream.Server{})
+	cfg := testConfig(up.URL)
+	cfg.Models = map[string]string{"my-alias": "claude-sonnet-4-5"}
+	p := newProxy(t, cfg, Options{})
+
+	send := func(model string) {
+		t.Helper()
+		if resp := post(t, p.URL, message(model, false), nil); resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status %d", model, resp.StatusCode)
+		}
+	}
+	series := func() []string {
+		t.Helper()
+		resp, err := http.Get(p.URL + "/metrics")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lines []string
+		for _, line := range strings.Split(readBody(t, resp), "\n") {
+			if line != "" && !strings.HasPrefix(line, "#") {
+				name, _, _ := strings.Cut(line, " ")
+				lines = append(lines, name)
+			}
+		}
+		return lines
+	}
+
+	for _, model := range []string{"claude-sonnet-4-x", "my-alias", "made-up"} {
+		send(model)
+	}
+	before := series()
+	for i := 0; i < 50; i++ {
+		send(fmt.Sprintf("claude-sonnet-4-%d", i))
+		send(fmt.Sprintf("made-up-%d", i))
+	}
+	after := series()
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("series grew from %d to %d", len(before), len(after))
+	}
+	for _, want := range []string{
+		`claude_proxy_requests_total{model="claude-sonnet-4*",upstream="default",status="200"}`,
+		`claude_proxy_requests_total{model="my-alias",upstream="default",status="200"}`,
+		`claude_proxy_requests_total{model="other",upstream="default",status="200"}`,
+	} {
+		found := false
+		for _, name := range after {
+			found = found || name == want
+		}
+		if !found {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
+
+// otlpSpan is the part of an exported OTLP span the tests look at.
+type otlpSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Attributes   []struct {
+		Key   string                 `json:"key"`
+		Value map[string]interface{} `json:"value"`
+	} `json:"attributes"`
+	Status struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+func (s otlpSpan) attr(key string) interface{} {
+	for _, kv := range s.Attributes {
+		if kv.Key == key {
+			for _, v := range kv.Value {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+// newCollector returns a fake OTLP/HTTP collector and a func returning the
+// spans it has received, by name.
+func newCollector(t *testing.T) (*httptest.Server, func() map[string][]otlpSpan) {
+	var mu sync.Mutex
+	var spans []otlpSpan
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []otlpSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() map[string][]otlpSpan {
+		mu.Lock()
+		defer mu.Unlock()
+		byName := map[string][]otlpSpan{}
+		for _, span := range spans {
+			byName[span.Name] = append(byName[span.Name], span)
+		}
+		return byName
+	}
+}
+
+func TestProxyTraces(t *testing.T) {
+	collector, spans := newCollector(t)
+	up := newUpstream(t, &mockupstream.Server{})
+	cfg := testConfig(up.URL)
+	cfg.Models = map[string]string{"claude-opus-*": "opus-upstream"}
+	cfg.Tracing = &config.TracingConfig{Endpoint: collector.URL, ServiceName: "proxy-test"}
+	p := newProxy(t, cfg, Options{})
+
+	const traceID, clientSpan = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	resp := post(t, p.URL, message("claude-opus-4-1", true), map[string]string{
+		"traceparent": "00-" + traceID + "-" + clientSpan + "-01",
+		"tracestate":  "vendor=1",
+	})
+	readBody(t, resp)
+	rec := p.records(t, 1)[0]
+	p.handler.tracer.Flush()
+
+	got := spans()
+	one := func(name string) otlpSpan {
+		t.Helper()
+		if len(got[name]) != 1 {
+			t.Fatalf("%d %q spans, want 1 (have %v)", len(got[name]), name, got)
+		}
+		return got[name][0]
+	}
+	server := one("POST /v1/messages")
+	attempt := one("upstream default")
+	if server.TraceID != traceID || server.ParentSpanID != clientSpan {
+		t.Errorf("server span %s/%s does not continue the client trace", server.TraceID, server.ParentSpanID)
+	}
+	if attempt.ParentSpanID != server.SpanID {
+		t.Errorf("upstream span parent = %s, want %s", attempt.ParentSpanID, server.SpanID)
+	}
+	for _, name := range []string{"auth", "connect", "time_to_first_byte"} {
+		if span := one(name); span.ParentSpanID != attempt.SpanID || span.TraceID != traceID {
+			t.Errorf("%s span = %+v, want a child of the upstream span", name, span)
+		}
+	}
+	if span := one("stream"); span.ParentSpanID != server.SpanID {
+		t.Errorf("stream span parent = %s", span.ParentSpanID)
+	}
+
+	for key, want := range map[string]interface{}{
+		"gen_ai.request.model":       "claude-opus-4-1",
+		"gen_ai.response.model":      "opus-upstream",
+		"gen_ai.usage.input_tokens":  fmt.Sprint(rec.InputTokens),
+		"gen_ai.usage.output_tokens": fmt.Sprint(rec.OutputTokens),
+		"http.response.status_code":  "200",
+		"claude_proxy.upstream":      "default",
+		"claude_proxy.stream":        true,
+	} {
+		if got := server.attr(key); got != want {
+			t.Errorf("server %s = %#v, want %#v", key, got, want)
+		}
+	}
+	if server.Status.Code != 0 {
+		t.Errorf("server status = %+v", server.Status)
+	}
+
+	// The upstream sees the proxy's upstream span as its parent.
+	header := up.last(t).Header
+	if want := "00-" + traceID + "-" + attempt.SpanID + "-01"; header.Get("traceparent") != want {
+		t.Errorf("upstream traceparent = %q, want %q", header.Get("traceparent"), want)
+	}
+	if header.Get("tracestate") != "vendor=1" {
+		t.Errorf("upstream tracestate = %q", header.Get("tracestate"))
+	}
+}
+
+func TestProxyTracesErrors(t *testing.T) {
+	collector, spans := newCollector(t)
+	up := newUpstream(t, &mockupstream.Server{Faults: []*mockupstream.Fault{{Status: http.StatusInternalServerError}}})
+	cfg := testConfig(up.URL)
+	cfg.Tracing = &config.TracingConfig{Endpoint: collector.URL}
+	p := newProxy(t, cfg, Options{})
+
+	readBody(t, post(t, p.URL, message("claude-sonnet-4-5", false), nil))
+	p.records(t, 1)
+	p.handler.tracer.Flush()
+
+	got := spans()
+	server, attempt := got["POST /v1/messages"], got["upstream default"]
+	if len(server) != 1 || len(attempt) != 1 {
+		t.Fatalf("spans = %v", got)
+	}
+	if server[0].ParentSpanID != "" || len(server[0].TraceID) != 32 {
+		t.Errorf("server span = %+v, want a new root", server[0])
+	}
+	if server[0].Status.Code != 2 || server[0].attr("http.response.status_code") != "500" {
+		t.Errorf("server span = %+v, want an error", server[0])
+	}
+	if attempt[0].Status.Code != 2 || attempt[0].Status.Message != "Internal Server Error" {
+		t.Errorf("upstream span status = %+v", attempt[0].Status)
+	}
+}
+
+func TestHealthReportsCachedCredentials(t *testing.T) {
+	up := newUpstream(t, &mockupstream.Server{})
+	cfg := testConfig(up.URL)
+	cfg.AuthType, cfg.APIKey, cfg.LoginURL = "opencode", writeAuthFile(t, "https://login.example", "oc-token"), "https://login.example"
+	p := newProxy(t, cfg, Options{})
+
+	hasToken := func() interface{} {
+		t.Helper()
+		resp, err := http.Get(p.URL + "/health")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var health struct {
+			Upstreams []map[string]interface{} `json:"upstreams"`
+		}
+		if err := json.Unmarshal([]byte(readBody(t, resp)), &health); err != nil {
+			t.Fatal(err)
+		}
+		return health.Upstreams[0]["has_token"]
+	}
+	if got := hasToken(); got != nil {
+		t.Errorf("has_token before any request = %v, want null", got)
+	}
+	post(t, p.URL, message("claude-sonnet-4-5", false), nil)
+	if got := hasToken(); got != true {
+		t.Errorf("has_token after a request = %v, want true", got)
+	}
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/schachte/claudecode-opencode-proxy/config"
+)
+
+// attemptError describes a failed attempt: the status and message reported
+// to the client if no upstream succeeds, and whether retrying may help.
+type attemptError struct {
+	status int
+	msg    string
+	retry  bool
+	err    error
+}
+
+func (e *attemptError) Error() string {
+	return e.err.Error()
+}
+
+// retryableStatus reports whether a response is worth retrying: rate limits,
+// Anthropic's 529 overloaded and server errors.
+func retryableStatus(resp *http.Response) bool {
+	if resp.Header.Get("x-should-retry") == "false" {
+		return false
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// backoff returns the delay before retry number attempt (1-based):
+// exponential with equal jitter, capped at the policy maximum.
+func backoff(policy config.RetryConfig, attempt int) time.Duration {
+	delay := time.Duration(policy.InitialBackoffMs) * time.Millisecond << (attempt - 1)
+	if max := time.Duration(policy.MaxBackoffMs) * time.Millisecond; delay > max || delay <= 0 {
+		delay = max
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP date.
+func retryAfter(h http.Header) (time.Duration, bool) {
+	value := h.Get("retry-after")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
+
+// retryDelay decides whether to retry after attempt and how long to wait. A
+// Retry-After longer than the maximum backoff is left for the client.
+func retryDelay(policy config.RetryConfig, attempt int, resp *http.Response, failure *attemptError) (time.Duration, bool) {
+	if attempt >= *policy.MaxAttempts {
+		return 0, false
+	}
+	if failure != nil {
+		return backoff(policy, attempt), failure.retry
+	}
+	if !retryableStatus(resp) {
+		return 0, false
+	}
+	delay := backoff(policy, attempt)
+	if after, ok := retryAfter(resp.Header); ok {
+		if after > time.Duration(policy.MaxBackoffMs)*time.Millisecond {
+			return 0, false
+		}
+		delay = max(delay, after)
+	}
+	return delay, true
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
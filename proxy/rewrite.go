@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/schachte/claudecode-opencode-proxy/config"
+)
+
+// ApplyRules rewrites body in place for the named upstream and returns the
+// names of the rules that changed it. Rules see the effects of earlier rules.
+func ApplyRules(rules []config.RewriteRule, reqPath, upstream string, body map[string]interface{}) []string {
+	var applied []string
+	for i, rule := range rules {
+		model, _ := body["model"].(string)
+		if !globMatch(rule.Match.Model, model) || !globMatch(rule.Match.Path, reqPath) ||
+			!globMatch(rule.Match.Upstream, upstream) {
+			continue
+		}
+		if applyRule(rule, body) {
+			name := rule.Name
+			if name == "" {
+				name = fmt.Sprintf("rule-%d", i+1)
+			}
+			applied = append(applied, name)
+		}
+	}
+	return applied
+}
+
+func applyRule(rule config.RewriteRule, body map[string]interface{}) bool {
+	keys := fieldPath(rule.Field)
+	if len(keys) == 0 {
+		return false
+	}
+
+	switch rule.Action {
+	case "delete":
+		parent, key := lookupParent(body, keys, false)
+		if parent == nil {
+			return false
+		}
+		if _, ok := parent[key]; !ok {
+			return false
+		}
+		delete(parent, key)
+		return true
+
+	case "set":
+		parent, key := lookupParent(body, keys, true)
+		parent[key] = rule.Value
+		return true
+
+	case "rename":
+		dest := fieldPath(rule.To)
+		if len(dest) == 0 {
+			return false
+		}
+		parent, key := lookupParent(body, keys, false)
+		if parent == nil {
+			return false
+		}
+		value, ok := parent[key]
+		if !ok {
+			return false
+		}
+		delete(parent, key)
+		destParent, destKey := lookupParent(body, dest, true)
+		destParent[destKey] = value
+		return true
+	}
+	return false
+}
+
+// lookupParent walks to the map holding the last key of keys. With create set,
+// missing or non-object intermediate fields are replaced by empty objects.
+func lookupParent(body map[string]interface{}, keys []string, create bool) (map[string]interface{}, string) {
+	current := body
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := current[key].(map[string]interface{})
+		if !ok {
+			if !create {
+				return nil, ""
+			}
+			next = make(map[string]interface{})
+			current[key] = next
+		}
+		current = next
+	}
+	return current, keys[len(keys)-1]
+}
+
+// cloneBody deep-copies a decoded JSON object so each upstream's rules start
+// from the client's body.
+func cloneBody(body map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(body))
+	for key, value := range body {
+		out[key] = cloneValue(value)
+	}
+	return out
+}
+
+func cloneValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return cloneBody(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = cloneValue(item)
+		}
+		return out
+	}
+	return v
+}
+
+func fieldPath(field string) []string {
+	field = strings.TrimPrefix(strings.TrimPrefix(field, "$"), ".")
+	if field == "" {
+		return nil
+	}
+	return strings.Split(field, ".")
+}
+
+func globMatch(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, value)
+	return ok
+}
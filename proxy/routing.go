@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"sort"
+
+	"github.com/schachte/claudecode-opencode-proxy/config"
+)
+
+// routeUpstreams orders upstreams for a model: the first matching route's
+// upstream goes first, followed by the rest in configured order.
+func routeUpstreams(cfg config.Config, upstreams []config.Upstream, model string) []config.Upstream {
+	for _, route := range cfg.Routes {
+		if model == "" || !globMatch(route.Model, model) {
+			continue
+		}
+		for i, up := range upstreams {
+			if up.Name != route.Upstream {
+				continue
+			}
+			ordered := make([]config.Upstream, 0, len(upstreams))
+			ordered = append(ordered, up)
+			ordered = append(ordered, upstreams[:i]...)
+			return append(ordered, upstreams[i+1:]...)
+		}
+	}
+	return upstreams
+}
+
+// mapModel returns the model name to send to an upstream. The upstream's own
+// map wins over the top-level one; exact keys win over globs.
+func mapModel(cfg config.Config, up config.Upstream, model string) string {
+	if model == "" {
+		return model
+	}
+	for _, models := range []map[string]string{up.Models, cfg.Models} {
+		if mapped, ok := models[model]; ok {
+			return mapped
+		}
+		patterns := make([]string, 0, len(models))
+		for pattern := range models {
+			patterns = append(patterns, pattern)
+		}
+		// Longest pattern first so the most specific glob wins.
+		sort.Slice(patterns, func(i, j int) bool {
+			if len(patterns[i]) != len(patterns[j]) {
+				return len(patterns[i]) > len(patterns[j])
+			}
+			return patterns[i] < patterns[j]
+		})
+		for _, pattern := range patterns {
+			if globMatch(pattern, model) {
+				return models[pattern]
+			}
+		}
+	}
+	return model
+}
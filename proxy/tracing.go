@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/schachte/claudecode-opencode-proxy/tracing"
+)
+
+// traceAttempt instruments an upstream request and returns it with a
+// finish func. finish records the connection and time-to-first-byte phases
+// as children of span; call it once client.Do returns.
+func traceAttempt(req *http.Request, span *tracing.Span) (*http.Request, func()) {
+	if span == nil {
+		return req, func() {}
+	}
+	var mu sync.Mutex
+	var connectStart, connectDone, wroteRequest, firstByte time.Time
+	var reused bool
+	mark := func(t *time.Time) {
+		mu.Lock()
+		if t.IsZero() {
+			*t = time.Now()
+		}
+		mu.Unlock()
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart:     func(httptrace.DNSStartInfo) { mark(&connectStart) },
+		ConnectStart: func(string, string) { mark(&connectStart) },
+		ConnectDone: func(string, string, error) {
+			mu.Lock()
+			connectDone = time.Now()
+			mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			mu.Lock()
+			connectDone = time.Now()
+			mu.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			mu.Lock()
+			reused = info.Reused
+			mu.Unlock()
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { mark(&wroteRequest) },
+		GotFirstResponseByte: func() { mark(&firstByte) },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	return req, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		span.SetAttr("net.connection.reused", reused)
+		if !connectStart.IsZero() && !connectDone.IsZero() {
+			span.ChildAt("connect", tracing.KindInternal, connectStart).EndAt(connectDone)
+		}
+		if !wroteRequest.IsZero() && !firstByte.IsZero() {
+			span.ChildAt("time_to_first_byte", tracing.KindInternal, wroteRequest).EndAt(firstByte)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector is a fake OTLP/HTTP endpoint that keeps every span it receives.
+type collector struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	spans   []otlpSpan
+	service string
+	header  http.Header
+	status  int
+}
+
+func newCollector(t *testing.T) *collector {
+	c := &collector{status: http.StatusOK}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		var req otlpRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.header = r.Header.Clone()
+		for _, rs := range req.ResourceSpans {
+			for _, attr := range rs.Resource.Attributes {
+				if attr.Key == "service.name" {
+					c.service, _ = attr.Value["stringValue"].(string)
+				}
+			}
+			for _, ss := range rs.ScopeSpans {
+				c.spans = append(c.spans, ss.Spans...)
+			}
+		}
+		w.WriteHeader(c.status)
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func (c *collector) received() []otlpSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]otlpSpan(nil), c.spans...)
+}
+
+func attr(span otlpSpan, key string) interface{} {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			for _, v := range kv.Value {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+func TestExport(t *testing.T) {
+	c := newCollector(t)
+	tracer := NewTracer(c.URL, "test-proxy", map[string]string{"Authorization": "Bearer otel"}, nil)
+	defer tracer.Close()
+	if tracer.Endpoint() != c.URL+"/v1/traces" {
+		t.Errorf("Endpoint = %s", tracer.Endpoint())
+	}
+
+	start := time.Unix(1700000000, 0)
+	root := tracer.Start("POST /v1/messages", KindServer, SpanContext{})
+	root.SetAttr("s", "v")
+	root.SetAttr("b", true)
+	root.SetAttr("i", 42)
+	root.SetAttr("i64", int64(1)<<40)
+	root.SetAttr("f", 0.25)
+	child := root.ChildAt("upstream", KindClient, start)
+	child.SetError("Bad Gateway")
+	child.EndAt(start.Add(time.Second))
+	root.End()
+	tracer.Flush()
+
+	spans := c.received()
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(spans))
+	}
+	up, server := spans[0], spans[1]
+	if server.ParentSpanID != "" || server.Kind != KindServer || server.Status.Code != 0 {
+		t.Errorf("server span = %+v", server)
+	}
+	if up.TraceID != server.TraceID || up.ParentSpanID != server.SpanID || up.Kind != KindClient {
+		t.Errorf("child span = %+v", up)
+	}
+	if up.Status.Code != 2 || up.Status.Message != "Bad Gateway" {
+		t.Errorf("error status = %+v", up.Status)
+	}
+	if up.StartTimeUnixNano != "1700000000000000000" || up.EndTimeUnixNano != "1700000001000000000" {
+		t.Errorf("times = %s..%s", up.StartTimeUnixNano, up.EndTimeUnixNano)
+	}
+	for key, want := range map[string]interface{}{"s": "v", "b": true, "i": "42", "i64": "1099511627776", "f": 0.25} {
+		if got := attr(server, key); got != want {
+			t.Errorf("attribute %s = %#v, want %#v", key, got, want)
+		}
+	}
+	if c.service != "test-proxy" || c.header.Get("Authorization") != "Bearer otel" {
+		t.Errorf("service = %q, headers = %v", c.service, c.header)
+	}
+}
+
+func TestCloseDrainsQueue(t *testing.T) {
+	c := newCollector(t)
+	tracer := NewTracer(c.URL+"/v1/traces", "svc", nil, nil)
+	for i := 0; i < 10; i++ {
+		tracer.Start("op", KindServer, SpanContext{}).End()
+	}
+	tracer.Close()
+	if n := len(c.received()); n != 10 {
+		t.Errorf("exported %d spans on Close, want 10", n)
+	}
+
+	// After Close, spans are dropped and Flush and Close return at once.
+	tracer.Start("late", KindServer, SpanContext{}).End()
+	tracer.Flush()
+	tracer.Close()
+	if n := len(c.received()); n != 10 {
+		t.Errorf("exported %d spans, want 10", n)
+	}
+}
+
+func TestFlushExportsFullBatches(t *testing.T) {
+	c := newCollector(t)
+	tracer := NewTracer(c.URL, "svc", nil, nil)
+	defer tracer.Close()
+	for i := 0; i < batchSize+5; i++ {
+		tracer.Start("op", KindServer, SpanContext{}).End()
+	}
+	tracer.Flush()
+	if n := len(c.received()); n != batchSize+5 {
+		t.Errorf("exported %d spans, want %d", n, batchSize+5)
+	}
+}
+
+func TestExportFailureWarnsOnce(t *testing.T) {
+	c := newCollector(t)
+	c.mu.Lock()
+	c.status = http.StatusServiceUnavailable
+	c.mu.Unlock()
+	var logs bytes.Buffer
+	tracer := NewTracer(c.URL, "svc", nil, slog.New(slog.NewTextHandler(&logs, nil)))
+	defer tracer.Close()
+	for i := 0; i < 3; i++ {
+		tracer.Start("op", KindServer, SpanContext{}).End()
+		tracer.Flush()
+	}
+	if n := strings.Count(logs.String(), "trace export failed: 503"); n != 1 {
+		t.Errorf("logged %d warnings, want 1:\n%s", n, logs.String())
+	}
+}
@@ -0,0 +1,171 @@
+// Package tracing records request spans and exports them to an
+// OpenTelemetry collector over OTLP/HTTP (JSON encoding). A nil *Tracer or
+// *Span is valid and does nothing, so callers need no enabled checks.
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind is the OTLP span kind.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// SpanContext identifies a span across process boundaries.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether the context carries a trace ID.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{}
+}
+
+// ParseTraceparent parses a W3C traceparent header
+// ("00-<trace-id>-<parent-id>-<flags>").
+func ParseTraceparent(header string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	var sc SpanContext
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return SpanContext{}, false
+	}
+	if !sc.IsValid() || sc.SpanID == [8]byte{} {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+// Traceparent formats the context as a W3C traceparent header.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), flags)
+}
+
+// Span is one timed operation. Its methods are safe for concurrent use.
+type Span struct {
+	tracer *Tracer
+	name   string
+	kind   Kind
+	ctx    SpanContext
+	parent [8]byte
+	start  time.Time
+
+	mu      sync.Mutex
+	end     time.Time
+	attrs   map[string]interface{}
+	errMsg  string
+	isError bool
+	ended   bool
+}
+
+// Start begins a root span for this process. A valid parent, usually from an
+// incoming traceparent, continues that trace and inherits its sampling
+// decision; otherwise a new, sampled trace starts.
+func (t *Tracer) Start(name string, kind Kind, parent SpanContext) *Span {
+	if t == nil {
+		return nil
+	}
+	s := &Span{tracer: t, name: name, kind: kind, start: time.Now(), attrs: map[string]interface{}{}}
+	if parent.IsValid() {
+		s.ctx.TraceID, s.ctx.Sampled, s.parent = parent.TraceID, parent.Sampled, parent.SpanID
+	} else {
+		rand.Read(s.ctx.TraceID[:])
+		s.ctx.Sampled = true
+	}
+	rand.Read(s.ctx.SpanID[:])
+	return s
+}
+
+// Child begins a span under s.
+func (s *Span) Child(name string, kind Kind) *Span {
+	return s.ChildAt(name, kind, time.Now())
+}
+
+// ChildAt begins a span under s with an explicit start time, for phases
+// measured after the fact.
+func (s *Span) ChildAt(name string, kind Kind, start time.Time) *Span {
+	if s == nil {
+		return nil
+	}
+	child := &Span{tracer: s.tracer, name: name, kind: kind, start: start, parent: s.ctx.SpanID, attrs: map[string]interface{}{}}
+	child.ctx.TraceID, child.ctx.Sampled = s.ctx.TraceID, s.ctx.Sampled
+	rand.Read(child.ctx.SpanID[:])
+	return child
+}
+
+// Context returns the span's identity, for propagation.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.ctx
+}
+
+// SetAttr sets an attribute. Values may be strings, bools, ints or floats.
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs[key] = value
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.isError, s.errMsg = true, msg
+	s.mu.Unlock()
+}
+
+// End finishes the span and queues it for export.
+func (s *Span) End() {
+	s.EndAt(time.Now())
+}
+
+// EndAt finishes the span at t. Only the first call has an effect.
+func (s *Span) EndAt(t time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended, s.end = true, t
+	s.mu.Unlock()
+	if s.ctx.Sampled {
+		s.tracer.enqueue(s)
+	}
+}
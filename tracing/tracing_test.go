@@ -0,0 +1,95 @@
+package tracing
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	tests := []struct {
+		header  string
+		ok      bool
+		sampled bool
+	}{
+		{"00-" + traceID + "-" + spanID + "-01", true, true},
+		{" 00-" + traceID + "-" + spanID + "-00 ", true, false},
+		{"01-" + traceID + "-" + spanID + "-03-future", true, true}, // later versions may append fields
+		{"ff-" + traceID + "-" + spanID + "-01", false, false},      // ff is never valid
+		{"00-" + strings.Repeat("0", 32) + "-" + spanID + "-01", false, false},
+		{"00-" + traceID + "-" + strings.Repeat("0", 16) + "-01", false, false},
+		{"00-" + traceID[:30] + "-" + spanID + "-01", false, false},
+		{"00-" + strings.Repeat("z", 32) + "-" + spanID + "-01", false, false},
+		{"00-" + traceID + "-" + spanID + "-zz", false, false},
+		{"00-" + traceID + "-" + spanID, false, false},
+		{"", false, false},
+	}
+	for _, tt := range tests {
+		sc, ok := ParseTraceparent(tt.header)
+		if ok != tt.ok || sc.Sampled != tt.sampled {
+			t.Errorf("ParseTraceparent(%q) = %+v, %v", tt.header, sc, ok)
+		}
+		if ok && sc.Traceparent()[3:52] != traceID+"-"+spanID {
+			t.Errorf("Traceparent() = %s", sc.Traceparent())
+		}
+	}
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	header := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := ParseTraceparent(header)
+	if !ok || sc.Traceparent() != header {
+		t.Errorf("round trip = %q, %v", sc.Traceparent(), ok)
+	}
+}
+
+func TestNilTracerAndSpan(t *testing.T) {
+	var tracer *Tracer
+	span := tracer.Start("op", KindServer, SpanContext{})
+	if span != nil {
+		t.Fatal("nil tracer started a span")
+	}
+	child := span.Child("child", KindInternal)
+	child.SetAttr("k", "v")
+	child.SetError("boom")
+	child.End()
+	if child.Context().IsValid() {
+		t.Error("nil span has a valid context")
+	}
+	tracer.Flush()
+	tracer.Close()
+	if tracer.Endpoint() != "" {
+		t.Error("nil tracer has an endpoint")
+	}
+}
+
+func TestStartContinuesParent(t *testing.T) {
+	tracer := &Tracer{queue: make(chan *Span, 1)}
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	span := tracer.Start("op", KindServer, parent)
+	if span.ctx.TraceID != parent.TraceID || span.parent != parent.SpanID || span.ctx.SpanID == parent.SpanID {
+		t.Errorf("span context = %+v, parent %x", span.ctx, span.parent)
+	}
+	// An unsampled trace is not exported.
+	span.End()
+	if len(tracer.queue) != 0 {
+		t.Error("unsampled span was queued")
+	}
+
+	root := tracer.Start("op", KindServer, SpanContext{})
+	if !root.ctx.IsValid() || !root.ctx.Sampled || root.parent != [8]byte{} {
+		t.Errorf("new root = %+v", root.ctx)
+	}
+	child := root.Child("child", KindInternal)
+	if child.ctx.TraceID != root.ctx.TraceID || child.parent != root.ctx.SpanID {
+		t.Errorf("child = %+v under %+v", child.ctx, root.ctx)
+	}
+	child.End()
+	child.End()
+	if len(tracer.queue) != 1 {
+		t.Errorf("queued %d spans, want 1 (End is idempotent)", len(tracer.queue))
+	}
+}
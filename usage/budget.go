@@ -0,0 +1,216 @@
+package usage
+
+import (
+	"fmt"
+	"path"
+	"sync"
+	"time"
+)
+
+// Budget caps estimated spend over a calendar day or month in local time.
+// Model is a glob; an empty model applies the budget to all traffic.
+type Budget struct {
+	Period string  `json:"period"`
+	Model  string  `json:"model,omitempty"`
+	Soft   float64 `json:"soft_usd,omitempty"`
+	Hard   float64 `json:"hard_usd,omitempty"`
+}
+
+// Label names the budget in logs and errors, e.g. "daily claude-opus-*".
+func (b Budget) Label() string {
+	if b.Model == "" {
+		return b.Period
+	}
+	return b.Period + " " + b.Model
+}
+
+// Matches reports whether spend on model counts against the budget.
+func (b Budget) Matches(model string) bool {
+	if b.Model == "" {
+		return true
+	}
+	ok, _ := path.Match(b.Model, model)
+	return ok
+}
+
+// Start returns the beginning of the period containing now.
+func (b Budget) Start(now time.Time) time.Time {
+	y, m, d := now.Date()
+	if b.Period == "monthly" {
+		return time.Date(y, m, 1, 0, 0, 0, 0, now.Location())
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+}
+
+// End returns the beginning of the next period, when spend resets.
+func (b Budget) End(now time.Time) time.Time {
+	start := b.Start(now)
+	if b.Period == "monthly" {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// Validate checks the period and caps.
+func (b Budget) Validate() error {
+	if b.Period != "daily" && b.Period != "monthly" {
+		return fmt.Errorf("period must be daily or monthly, got %q", b.Period)
+	}
+	if _, err := path.Match(b.Model, ""); err != nil {
+		return fmt.Errorf("invalid model pattern %q", b.Model)
+	}
+	if b.Soft <= 0 && b.Hard <= 0 {
+		return fmt.Errorf("a soft or hard cap is required")
+	}
+	if b.Soft > 0 && b.Hard > 0 && b.Soft > b.Hard {
+		return fmt.Errorf("soft cap $%.2f is above hard cap $%.2f", b.Soft, b.Hard)
+	}
+	return nil
+}
+
+// BudgetState is a budget with its spend in the current period.
+type BudgetState struct {
+	Budget
+	Spent  float64   `json:"spent_usd"`
+	Resets time.Time `json:"resets"`
+	Level  string    `json:"level"`
+}
+
+// Level values for BudgetState.
+const (
+	BudgetOK   = "ok"
+	BudgetSoft = "soft"
+	BudgetHard = "hard"
+)
+
+func (s BudgetState) level() string {
+	switch {
+	case s.Hard > 0 && s.Spent >= s.Hard:
+		return BudgetHard
+	case s.Soft > 0 && s.Spent >= s.Soft:
+		return BudgetSoft
+	}
+	return BudgetOK
+}
+
+// Message describes the state in one line for logs, status and errors.
+func (s BudgetState) Message() string {
+	limit := s.Hard
+	kind := "hard"
+	if s.Level != BudgetHard && s.Soft > 0 {
+		limit, kind = s.Soft, "soft"
+	}
+	return fmt.Sprintf("%s budget: $%.2f spent of $%.2f %s cap, resets %s",
+		s.Label(), s.Spent, limit, kind, s.Resets.Format("2006-01-02 15:04"))
+}
+
+// Budgets tracks spend against a set of budgets. Spend is seeded from the
+// ledger, so it survives restarts. It is safe for concurrent use.
+type Budgets struct {
+	mu      sync.Mutex
+	budgets []Budget
+	spent   []float64
+	starts  []time.Time
+}
+
+// NewBudgets seeds spend from ledger records. Records written without a cost
+// are priced with prices.
+func NewBudgets(budgets []Budget, records []Record, prices PriceTable, now time.Time) *Budgets {
+	b := &Budgets{
+		budgets: budgets,
+		spent:   make([]float64, len(budgets)),
+		starts:  make([]time.Time, len(budgets)),
+	}
+	for i, budget := range budgets {
+		b.starts[i] = budget.Start(now)
+	}
+	for _, rec := range records {
+		cost := rec.CostUSD
+		if cost == 0 && !rec.IsZero() {
+			cost = prices.ServedCost(rec.Model, rec.UpstreamModel, rec.Usage)
+		}
+		for i, budget := range budgets {
+			if budget.Matches(rec.Model) && !rec.Time.Before(b.starts[i]) {
+				b.spent[i] += cost
+			}
+		}
+	}
+	return b
+}
+
+// Since returns the earliest period start, the point from which the ledger
+// must be read to seed NewBudgets.
+func Since(budgets []Budget, now time.Time) time.Time {
+	since := now
+	for _, budget := range budgets {
+		if start := budget.Start(now); start.Before(since) {
+			since = start
+		}
+	}
+	return since
+}
+
+// rollover resets spend for budgets whose period has ended. Callers hold mu.
+func (b *Budgets) rollover(now time.Time) {
+	for i, budget := range b.budgets {
+		if start := budget.Start(now); start.After(b.starts[i]) {
+			b.starts[i], b.spent[i] = start, 0
+		}
+	}
+}
+
+func (b *Budgets) state(i int, now time.Time) BudgetState {
+	s := BudgetState{Budget: b.budgets[i], Spent: b.spent[i], Resets: b.budgets[i].End(now)}
+	s.Level = s.level()
+	return s
+}
+
+// Check returns the first budget covering model whose hard cap is reached.
+func (b *Budgets) Check(model string, now time.Time) (BudgetState, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover(now)
+	for i, budget := range b.budgets {
+		if budget.Matches(model) {
+			if s := b.state(i, now); s.Level == BudgetHard {
+				return s, true
+			}
+		}
+	}
+	return BudgetState{}, false
+}
+
+// Add records spend on model and returns the budgets whose level rose as a
+// result, so each threshold is reported once per period.
+func (b *Budgets) Add(model string, cost float64, now time.Time) []BudgetState {
+	if cost <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover(now)
+	var crossed []BudgetState
+	for i, budget := range b.budgets {
+		if !budget.Matches(model) {
+			continue
+		}
+		before := b.state(i, now).Level
+		b.spent[i] += cost
+		if s := b.state(i, now); s.Level != before {
+			crossed = append(crossed, s)
+		}
+	}
+	return crossed
+}
+
+// Snapshot returns the state of every budget.
+func (b *Budgets) Snapshot(now time.Time) []BudgetState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover(now)
+	states := make([]BudgetState, len(b.budgets))
+	for i := range b.budgets {
+		states[i] = b.state(i, now)
+	}
+	return states
+}
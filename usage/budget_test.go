@@ -0,0 +1,129 @@
+package usage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBudgetsSoftAndHardCaps(t *testing.T) {
+	now := time.Date(2025, 6, 10, 12, 0, 0, 0, time.UTC)
+	b := NewBudgets([]Budget{
+		{Period: "daily", Soft: 5, Hard: 10},
+		{Period: "daily", Model: "claude-opus-*", Hard: 2},
+	}, nil, DefaultPrices, now)
+
+	if _, exceeded := b.Check("claude-opus-4-1", now); exceeded {
+		t.Fatal("fresh budgets exceeded")
+	}
+	// Opus spend counts against both budgets and reaches the opus cap.
+	crossed := b.Add("claude-opus-4-1", 2, now)
+	if len(crossed) != 1 || crossed[0].Model != "claude-opus-*" || crossed[0].Level != BudgetHard {
+		t.Errorf("crossed = %+v", crossed)
+	}
+	if s, exceeded := b.Check("claude-opus-4-1", now); !exceeded || s.Label() != "daily claude-opus-*" {
+		t.Errorf("opus Check = %+v, %v", s, exceeded)
+	}
+	if _, exceeded := b.Check("claude-sonnet-4-5", now); exceeded {
+		t.Error("per-model cap blocked another model")
+	}
+
+	// Sonnet spend reaches the global soft cap, which warns but admits.
+	crossed = b.Add("claude-sonnet-4-5", 3.5, now)
+	if len(crossed) != 1 || crossed[0].Level != BudgetSoft || crossed[0].Model != "" {
+		t.Errorf("crossed = %+v", crossed)
+	}
+	if crossed := b.Add("claude-sonnet-4-5", 1, now); len(crossed) != 0 {
+		t.Errorf("soft cap reported twice: %+v", crossed)
+	}
+	if _, exceeded := b.Check("claude-sonnet-4-5", now); exceeded {
+		t.Error("soft cap rejected a request")
+	}
+
+	// The global hard cap blocks every model.
+	b.Add("claude-haiku-4-5", 4, now)
+	if s, exceeded := b.Check("claude-haiku-4-5", now); !exceeded || s.Label() != "daily" || s.Spent != 10.5 {
+		t.Errorf("global Check = %+v, %v", s, exceeded)
+	}
+	if b.Add("claude-haiku-4-5", 0, now) != nil {
+		t.Error("zero cost crossed a threshold")
+	}
+}
+
+func TestBudgetsRollover(t *testing.T) {
+	now := time.Date(2025, 6, 30, 23, 0, 0, 0, time.UTC)
+	b := NewBudgets([]Budget{
+		{Period: "daily", Hard: 1},
+		{Period: "monthly", Hard: 3},
+	}, nil, DefaultPrices, now)
+	b.Add("m", 1, now)
+	if _, exceeded := b.Check("m", now); !exceeded {
+		t.Fatal("daily cap not reached")
+	}
+
+	nextDay := time.Date(2025, 7, 1, 0, 30, 0, 0, time.UTC)
+	if _, exceeded := b.Check("m", nextDay); exceeded {
+		t.Error("daily cap did not reset at midnight")
+	}
+	got := b.Snapshot(nextDay)
+	if got[0].Spent != 0 || got[1].Spent != 0 {
+		t.Errorf("spend after the month ended = %v, %v", got[0].Spent, got[1].Spent)
+	}
+	if want := time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC); !got[1].Resets.Equal(want) {
+		t.Errorf("monthly resets %v, want %v", got[1].Resets, want)
+	}
+
+	// Within a month, the monthly spend carries over days.
+	b.Add("m", 0.9, nextDay)
+	dayAfter := nextDay.AddDate(0, 0, 1)
+	b.Add("m", 0.9, dayAfter)
+	got = b.Snapshot(dayAfter)
+	if got[0].Spent != 0.9 || got[1].Spent != 1.8 {
+		t.Errorf("spent = %v, %v; want 0.9, 1.8", got[0].Spent, got[1].Spent)
+	}
+}
+
+func TestNewBudgetsSeedsFromLedger(t *testing.T) {
+	now := time.Date(2025, 6, 10, 12, 0, 0, 0, time.UTC)
+	records := []Record{
+		{Time: now.Add(-time.Hour), Model: "claude-opus-4-1", CostUSD: 1.5},
+		{Time: now.AddDate(0, 0, -1), Model: "claude-opus-4-1", CostUSD: 4}, // yesterday
+		{Time: now.AddDate(0, -1, 0), Model: "claude-opus-4-1", CostUSD: 8}, // last month
+		// Unpriced records are priced at the served model: 1M Sonnet input.
+		{Time: now.Add(-2 * time.Hour), Model: "claude-opus-4-1", UpstreamModel: "claude-sonnet-4-5", Usage: Usage{InputTokens: 1_000_000}},
+		{Time: now.Add(-time.Minute), Model: "claude-haiku-4-5", CostUSD: 0.25},
+	}
+	budgets := []Budget{
+		{Period: "daily", Model: "claude-opus-*", Hard: 100},
+		{Period: "monthly", Hard: 100},
+	}
+	if since := Since(budgets, now); !since.Equal(time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Since = %v", since)
+	}
+	var spent []float64
+	for _, s := range NewBudgets(budgets, records, DefaultPrices, now).Snapshot(now) {
+		spent = append(spent, s.Spent)
+	}
+	if want := []float64{4.5, 8.75}; !reflect.DeepEqual(spent, want) {
+		t.Errorf("seeded spend = %v, want %v", spent, want)
+	}
+}
+
+func TestBudgetValidate(t *testing.T) {
+	tests := []struct {
+		budget Budget
+		ok     bool
+	}{
+		{Budget{Period: "daily", Hard: 1}, true},
+		{Budget{Period: "monthly", Model: "claude-*", Soft: 1, Hard: 2}, true},
+		{Budget{Period: "weekly", Hard: 1}, false},
+		{Budget{Period: "daily"}, false},
+		{Budget{Period: "daily", Soft: 3, Hard: 2}, false},
+		{Budget{Period: "daily", Model: "[", Hard: 1}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.budget.Validate(); (err == nil) != tt.ok {
+			t.Errorf("Validate(%+v) = %v", tt.budget, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package usage
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ProjectHeader carries the working directory of the `run` session that
+// launched Claude Code. The proxy records it and never forwards it upstream.
+const ProjectHeader = "X-Claude-Proxy-Project"
+
+// Record is one proxied request in the ledger.
+type Record struct {
+	Time          time.Time `json:"ts"`
+	Request       int       `json:"request"`
+	RequestID     string    `json:"request_id,omitempty"`
+	Model         string    `json:"model,omitempty"`
+	UpstreamModel string    `json:"upstream_model,omitempty"`
+	Upstream      string    `json:"upstream,omitempty"`
+	Path          string    `json:"path"`
+	Stream        bool      `json:"stream"`
+	Status        int       `json:"status"`
+	LatencyMs     int64     `json:"latency_ms"`
+	Canceled      bool      `json:"canceled,omitempty"`
+	Budget        string    `json:"budget,omitempty"` // the hard cap that rejected the request
+	Project       string    `json:"project,omitempty"`
+	CostUSD       float64   `json:"cost_usd"`
+	Usage
+}
+
+// Ledger appends records to a JSONL file. It is safe for concurrent use.
+type Ledger struct {
+	mu   sync.Mutex
+	path string
+	w    io.Writer
+	file *os.File // set once the ledger has opened path itself
+}
+
+// NewLedger appends to the file at path, which is created on first use.
+func NewLedger(path string) *Ledger {
+	return &Ledger{path: path}
+}
+
+// NewLedgerWriter writes records to w.
+func NewLedgerWriter(w io.Writer) *Ledger {
+	return &Ledger{w: w}
+}
+
+func (l *Ledger) Append(rec Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.w == nil {
+		if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		l.w, l.file = f, f
+	}
+	_, err = l.w.Write(append(data, '\n'))
+	return err
+}
+
+// Close closes the ledger file if the ledger opened it. A later Append
+// reopens it.
+func (l *Ledger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.w, l.file = nil, nil
+	return err
+}
+
+// ReadLedger returns the records at or after since. A missing ledger is not
+// an error; malformed lines are skipped.
+func ReadLedger(path string, since time.Time) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		if !rec.Time.Before(since) {
+			records = append(records, rec)
+		}
+	}
+	return records, scanner.Err()
+}
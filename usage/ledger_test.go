@@ -0,0 +1,77 @@
+package usage
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadLedgerSince(t *testing.T) {
+	all := readTestLedger(t, time.Time{})
+	if len(all) != 5 {
+		t.Fatalf("read %d records, want 5 (the malformed line skipped)", len(all))
+	}
+	since := time.Date(2025, 6, 1, 11, 0, 0, 0, time.UTC)
+	recent := readTestLedger(t, since)
+	if len(recent) != 3 || !recent[0].Time.Equal(since) {
+		t.Errorf("records since %v = %+v", since, recent)
+	}
+
+	missing, err := ReadLedger(filepath.Join(t.TempDir(), "none.jsonl"), time.Time{})
+	if err != nil || missing != nil {
+		t.Errorf("missing ledger = %v, %v", missing, err)
+	}
+}
+
+func TestLedgerAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "usage.jsonl")
+	ledger := NewLedger(path)
+	first := Record{Time: time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC), Request: 1, Model: "m", Status: 200,
+		Usage: Usage{InputTokens: 3, CacheWriteTokens: 4}}
+	if err := ledger.Append(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := ledger.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Append reopens a closed ledger.
+	second := first
+	second.Request = 2
+	if err := ledger.Append(second); err != nil {
+		t.Fatal(err)
+	}
+	ledger.Close()
+
+	got, err := ReadLedger(path, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []Record{first, second}) {
+		t.Errorf("ledger = %+v", got)
+	}
+}
+
+func TestLedgerWriter(t *testing.T) {
+	var buf bytes.Buffer
+	ledger := NewLedgerWriter(&buf)
+	if err := ledger.Append(Record{Model: "m", UpstreamModel: "u"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ledger.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var rec map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec["upstream_model"] != "u" || rec["cache_read_input_tokens"] != 0.0 {
+		t.Errorf("record = %s", buf.Bytes())
+	}
+	if _, err := os.Stat("usage.jsonl"); err == nil {
+		t.Error("writer ledger created a file")
+	}
+}
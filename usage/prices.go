@@ -0,0 +1,91 @@
+package usage
+
+import (
+	"path"
+	"sort"
+)
+
+// Price is in USD per million tokens.
+type Price struct {
+	Input      float64 `json:"input"`
+	Output     float64 `json:"output"`
+	CacheRead  float64 `json:"cache_read"`
+	CacheWrite float64 `json:"cache_write"`
+}
+
+// Cost returns the dollar cost of u at this price.
+func (p Price) Cost(u Usage) float64 {
+	return (float64(u.InputTokens)*p.Input +
+		float64(u.OutputTokens)*p.Output +
+		float64(u.CacheReadTokens)*p.CacheRead +
+		float64(u.CacheWriteTokens)*p.CacheWrite) / 1e6
+}
+
+// PriceTable maps model names or glob patterns to prices.
+type PriceTable map[string]Price
+
+// DefaultPrices are Anthropic list prices. Cache writes use the 5-minute
+// TTL rate.
+var DefaultPrices = PriceTable{
+	"claude-opus-4-5*":   {Input: 5, Output: 25, CacheRead: 0.5, CacheWrite: 6.25},
+	"claude-opus-4*":     {Input: 15, Output: 75, CacheRead: 1.5, CacheWrite: 18.75},
+	"claude-sonnet-4*":   {Input: 3, Output: 15, CacheRead: 0.3, CacheWrite: 3.75},
+	"claude-haiku-4*":    {Input: 1, Output: 5, CacheRead: 0.1, CacheWrite: 1.25},
+	"claude-3-7-sonnet*": {Input: 3, Output: 15, CacheRead: 0.3, CacheWrite: 3.75},
+	"claude-3-5-sonnet*": {Input: 3, Output: 15, CacheRead: 0.3, CacheWrite: 3.75},
+	"claude-3-5-haiku*":  {Input: 0.8, Output: 4, CacheRead: 0.08, CacheWrite: 1},
+	"claude-3-opus*":     {Input: 15, Output: 75, CacheRead: 1.5, CacheWrite: 18.75},
+	"claude-3-haiku*":    {Input: 0.25, Output: 1.25, CacheRead: 0.03, CacheWrite: 0.3},
+}
+
+// Prices returns the default table with overrides applied on top.
+func Prices(overrides map[string]Price) PriceTable {
+	table := make(PriceTable, len(DefaultPrices)+len(overrides))
+	for model, price := range DefaultPrices {
+		table[model] = price
+	}
+	for model, price := range overrides {
+		table[model] = price
+	}
+	return table
+}
+
+// Lookup finds the price for a model: an exact key first, then the longest
+// matching glob.
+func (t PriceTable) Lookup(model string) (Price, bool) {
+	if price, ok := t[model]; ok {
+		return price, true
+	}
+	patterns := make([]string, 0, len(t))
+	for pattern := range t {
+		patterns = append(patterns, pattern)
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if len(patterns[i]) != len(patterns[j]) {
+			return len(patterns[i]) > len(patterns[j])
+		}
+		return patterns[i] < patterns[j]
+	})
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, model); ok {
+			return t[pattern], true
+		}
+	}
+	return Price{}, false
+}
+
+// Cost prices u for a model; unknown models cost nothing.
+func (t PriceTable) Cost(model string, u Usage) float64 {
+	price, _ := t.Lookup(model)
+	return price.Cost(u)
+}
+
+// ServedCost prices u at the model the upstream served. When that model has
+// no price, such as a local model behind a mapping, the requested model's
+// price is used instead.
+func (t PriceTable) ServedCost(requested, served string, u Usage) float64 {
+	if price, ok := t.Lookup(served); ok && served != "" {
+		return price.Cost(u)
+	}
+	return t.Cost(requested, u)
+}
@@ -0,0 +1,65 @@
+package usage
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPriceLookup(t *testing.T) {
+	table := Prices(map[string]Price{
+		"claude-sonnet-4-5-20250929": {Input: 1},
+		"local-*":                    {Input: 0.1},
+		"claude-haiku-4*":            {Input: 2},
+	})
+	tests := []struct {
+		model string
+		input float64
+		ok    bool
+	}{
+		{"claude-sonnet-4-5-20250929", 1, true}, // exact key beats the glob
+		{"claude-sonnet-4-5", 3, true},
+		{"claude-opus-4-5-20251101", 5, true}, // longest glob wins over claude-opus-4*
+		{"claude-opus-4-1", 15, true},
+		{"claude-haiku-4-5", 2, true}, // override replaces the default
+		{"local-qwen", 0.1, true},
+		{"gpt-4o", 0, false},
+	}
+	for _, tt := range tests {
+		price, ok := table.Lookup(tt.model)
+		if ok != tt.ok || price.Input != tt.input {
+			t.Errorf("Lookup(%q) = %+v, %v; want input %v, %v", tt.model, price, ok, tt.input, tt.ok)
+		}
+	}
+	if DefaultPrices["claude-haiku-4*"].Input != 1 {
+		t.Error("Prices modified DefaultPrices")
+	}
+}
+
+func TestPriceCost(t *testing.T) {
+	u := Usage{InputTokens: 1_000_000, OutputTokens: 100_000, CacheReadTokens: 2_000_000, CacheWriteTokens: 400_000}
+	// Sonnet 4: 3 + 1.5 + 0.6 + 1.5
+	if got := DefaultPrices.Cost("claude-sonnet-4-5", u); math.Abs(got-6.6) > 1e-9 {
+		t.Errorf("Cost = %v, want 6.6", got)
+	}
+	if got := DefaultPrices.Cost("unknown-model", u); got != 0 {
+		t.Errorf("unknown model cost %v", got)
+	}
+}
+
+func TestServedCost(t *testing.T) {
+	u := Usage{InputTokens: 1_000_000}
+	tests := []struct {
+		requested, served string
+		want              float64
+	}{
+		{"claude-opus-4-1", "claude-sonnet-4-5", 3}, // priced at the mapped model
+		{"claude-opus-4-1", "local-llama", 15},      // unpriced mapping falls back
+		{"claude-opus-4-1", "", 15},                 // no mapping
+		{"unknown-model", "also-unknown", 0},
+	}
+	for _, tt := range tests {
+		if got := DefaultPrices.ServedCost(tt.requested, tt.served, u); got != tt.want {
+			t.Errorf("ServedCost(%q, %q) = %v, want %v", tt.requested, tt.served, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package usage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Row is one group of ledger records in a report.
+type Row struct {
+	Keys      map[string]string `json:"keys"`
+	Requests  int               `json:"requests"`
+	Errors    int               `json:"errors"`
+	LatencyMs int64             `json:"avg_latency_ms"`
+	CostUSD   float64           `json:"cost_usd"`
+	Usage
+}
+
+// GroupKeys are the dimensions a report can be grouped by.
+var GroupKeys = []string{"day", "model", "upstream_model", "project", "upstream"}
+
+// Summarize groups records by the given keys (see GroupKeys). Rows are
+// sorted by their key values.
+func Summarize(records []Record, by []string) ([]Row, error) {
+	for _, key := range by {
+		if !validKey(key) {
+			return nil, fmt.Errorf("unknown group %q (use %s)", key, strings.Join(GroupKeys, ", "))
+		}
+	}
+
+	rows := make(map[string]*Row)
+	latency := make(map[string]int64)
+	var order []string
+	for _, rec := range records {
+		keys := make(map[string]string, len(by))
+		parts := make([]string, len(by))
+		for i, key := range by {
+			keys[key] = keyValue(rec, key)
+			parts[i] = keys[key]
+		}
+		id := strings.Join(parts, "\x00")
+
+		row, ok := rows[id]
+		if !ok {
+			row = &Row{Keys: keys}
+			rows[id] = row
+			order = append(order, id)
+		}
+		row.Requests++
+		if rec.Status >= 400 || rec.Status == 0 {
+			row.Errors++
+		}
+		row.Add(rec.Usage)
+		row.CostUSD += rec.CostUSD
+		latency[id] += rec.LatencyMs
+	}
+
+	sort.Strings(order)
+	out := make([]Row, 0, len(order))
+	for _, id := range order {
+		row := rows[id]
+		row.LatencyMs = latency[id] / int64(row.Requests)
+		out = append(out, *row)
+	}
+	return out, nil
+}
+
+func validKey(key string) bool {
+	for _, k := range GroupKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func keyValue(rec Record, key string) string {
+	var value string
+	switch key {
+	case "day":
+		value = rec.Time.Local().Format("2006-01-02")
+	case "model":
+		value = rec.Model
+	case "upstream_model":
+		value = rec.UpstreamModel
+	case "project":
+		value = rec.Project
+	case "upstream":
+		value = rec.Upstream
+	}
+	if value == "" {
+		return "-"
+	}
+	return value
+}
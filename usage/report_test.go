@@ -0,0 +1,48 @@
+package usage
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readTestLedger(t *testing.T, since time.Time) []Record {
+	t.Helper()
+	records, err := ReadLedger(filepath.Join("testdata", "ledger.jsonl"), since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestSummarize(t *testing.T) {
+	// Days are taken in local time.
+	local := time.Local
+	time.Local = time.UTC
+	t.Cleanup(func() { time.Local = local })
+
+	records := readTestLedger(t, time.Time{})
+	for _, by := range [][]string{
+		{"day"},
+		{"model"},
+		{"project"},
+		{"upstream_model"},
+		{"day", "model", "project"},
+	} {
+		name := strings.Join(by, "_")
+		t.Run(name, func(t *testing.T) {
+			rows, err := Summarize(records, by)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, filepath.Join("testdata", "reports", name+".json"), rows)
+		})
+	}
+}
+
+func TestSummarizeRejectsUnknownKey(t *testing.T) {
+	if _, err := Summarize(nil, []string{"model", "color"}); err == nil || !strings.Contains(err.Error(), "color") {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+// Package usage extracts token usage from Anthropic responses and keeps
+// running totals.
+package usage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+type Usage struct {
+	InputTokens      int `json:"input_tokens"`
+	OutputTokens     int `json:"output_tokens"`
+	CacheReadTokens  int `json:"cache_read_input_tokens"`
+	CacheWriteTokens int `json:"cache_creation_input_tokens"`
+}
+
+func (u *Usage) Add(o Usage) {
+	u.InputTokens += o.InputTokens
+	u.OutputTokens += o.OutputTokens
+	u.CacheReadTokens += o.CacheReadTokens
+	u.CacheWriteTokens += o.CacheWriteTokens
+}
+
+func (u Usage) IsZero() bool {
+	return u == Usage{}
+}
+
+// merge overlays the non-zero fields of o. Stream events report cumulative
+// counts, so later values replace earlier ones.
+func (u *Usage) merge(o Usage) {
+	if o.InputTokens != 0 {
+		u.InputTokens = o.InputTokens
+	}
+	if o.OutputTokens != 0 {
+		u.OutputTokens = o.OutputTokens
+	}
+	if o.CacheReadTokens != 0 {
+		u.CacheReadTokens = o.CacheReadTokens
+	}
+	if o.CacheWriteTokens != 0 {
+		u.CacheWriteTokens = o.CacheWriteTokens
+	}
+}
+
+func (u Usage) String() string {
+	return fmt.Sprintf("in=%d out=%d cache_read=%d cache_write=%d",
+		u.InputTokens, u.OutputTokens, u.CacheReadTokens, u.CacheWriteTokens)
+}
+
+// StreamParser accumulates usage from the message_start and message_delta
+// events of an Anthropic SSE stream.
+type StreamParser struct {
+	Usage Usage
+
+	final      bool // message_delta seen, so OutputTokens is the real count
+	deltaChars int
+}
+
+// Line inspects one SSE line; anything other than a usage-bearing data line
+// or a content delta is ignored.
+func (p *StreamParser) Line(line []byte) {
+	data, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:"))
+	if !ok {
+		return
+	}
+	if bytes.Contains(data, []byte(`"content_block_delta"`)) {
+		p.countDelta(data)
+		return
+	}
+	if !bytes.Contains(data, []byte(`"usage"`)) {
+		return
+	}
+	var event struct {
+		Type    string `json:"type"`
+		Message struct {
+			Usage Usage `json:"usage"`
+		} `json:"message"`
+		Usage Usage `json:"usage"`
+	}
+	if err := json.Unmarshal(bytes.TrimSpace(data), &event); err != nil {
+		return
+	}
+	switch event.Type {
+	case "message_start":
+		p.Usage.merge(event.Message.Usage)
+	case "message_delta":
+		p.Usage.merge(event.Usage)
+		p.final = true
+	}
+}
+
+func (p *StreamParser) countDelta(data []byte) {
+	var event struct {
+		Delta struct {
+			Text        string `json:"text"`
+			Thinking    string `json:"thinking"`
+			PartialJSON string `json:"partial_json"`
+		} `json:"delta"`
+	}
+	if json.Unmarshal(bytes.TrimSpace(data), &event) == nil {
+		p.deltaChars += len(event.Delta.Text) + len(event.Delta.Thinking) + len(event.Delta.PartialJSON)
+	}
+}
+
+// Partial returns the usage of a stream that was cut short. Output tokens
+// are only reported at the end of a stream, so until then they are
+// estimated at four bytes of generated content per token.
+func (p *StreamParser) Partial() Usage {
+	u := p.Usage
+	if !p.final && p.deltaChars/4 > u.OutputTokens {
+		u.OutputTokens = p.deltaChars / 4
+	}
+	return u
+}
+
+// FromResponse reads the usage object of a non-streaming response body.
+func FromResponse(body []byte) (Usage, bool) {
+	var resp struct {
+		Usage *Usage `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || resp.Usage == nil {
+		return Usage{}, false
+	}
+	return *resp.Usage, true
+}
+
+// Totals is the running usage for one model.
+type Totals struct {
+	Requests int     `json:"requests"`
+	CostUSD  float64 `json:"cost_usd"`
+	Usage
+}
+
+// Aggregate keeps in-memory totals by model. It is safe for concurrent use.
+type Aggregate struct {
+	mu      sync.Mutex
+	byModel map[string]*Totals
+}
+
+func NewAggregate() *Aggregate {
+	return &Aggregate{byModel: make(map[string]*Totals)}
+}
+
+func (a *Aggregate) Record(model string, u Usage, cost float64) {
+	if model == "" {
+		model = "unknown"
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	t, ok := a.byModel[model]
+	if !ok {
+		t = &Totals{}
+		a.byModel[model] = t
+	}
+	t.Requests++
+	t.CostUSD += cost
+	t.Add(u)
+}
+
+// Snapshot returns a copy of the totals keyed by model.
+func (a *Aggregate) Snapshot() map[string]Totals {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := make(map[string]Totals, len(a.byModel))
+	for model, t := range a.byModel {
+		out[model] = *t
+	}
+	return out
+}
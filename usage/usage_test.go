@@ -0,0 +1,117 @@
+package usage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the expected files in testdata")
+
+// fixtures returns the base paths (without suffix) of testdata/dir/*suffix.
+func fixtures(t *testing.T, dir, suffix string) []string {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join("testdata", dir, "*"+suffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatalf("no fixtures in testdata/%s", dir)
+	}
+	for i, path := range paths {
+		paths[i] = strings.TrimSuffix(path, suffix)
+	}
+	return paths
+}
+
+// checkGolden compares got, marshaled as JSON, with the file at path, or
+// writes it with -update. JSON is compared by value.
+func checkGolden(t *testing.T, path string, got interface{}) {
+	t.Helper()
+	data, err := json.MarshalIndent(got, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, '\n')
+	if *update {
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	var va, vb interface{}
+	if json.Unmarshal(want, &va) != nil || json.Unmarshal(data, &vb) != nil || !reflect.DeepEqual(va, vb) {
+		t.Errorf("%s mismatch\ngot:\n%s\nwant:\n%s", path, data, want)
+	}
+}
+
+func TestStreamParser(t *testing.T) {
+	for _, base := range fixtures(t, "streams", ".sse") {
+		t.Run(filepath.Base(base), func(t *testing.T) {
+			data, err := os.ReadFile(base + ".sse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var parser StreamParser
+			reader := bufio.NewReader(bytes.NewReader(data))
+			for {
+				line, err := reader.ReadBytes('\n')
+				if len(line) > 0 {
+					parser.Line(line)
+				}
+				if err != nil {
+					break
+				}
+			}
+			checkGolden(t, base+".usage.json", map[string]Usage{
+				"usage":   parser.Usage,
+				"partial": parser.Partial(),
+			})
+		})
+	}
+}
+
+func TestFromResponse(t *testing.T) {
+	for _, base := range fixtures(t, "responses", ".response.json") {
+		t.Run(filepath.Base(base), func(t *testing.T) {
+			data, err := os.ReadFile(base + ".response.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, ok := FromResponse(data)
+			checkGolden(t, base+".usage.json", map[string]interface{}{"usage": u, "ok": ok})
+		})
+	}
+}
+
+func TestFromResponseRejectsInvalidJSON(t *testing.T) {
+	if u, ok := FromResponse([]byte(`{"usage":`)); ok || !u.IsZero() {
+		t.Errorf("FromResponse = %v, %v", u, ok)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	a := NewAggregate()
+	a.Record("claude-sonnet-4-5", Usage{InputTokens: 10, OutputTokens: 5}, 0.5)
+	a.Record("claude-sonnet-4-5", Usage{InputTokens: 1, CacheReadTokens: 100}, 0.25)
+	a.Record("", Usage{OutputTokens: 1}, 0)
+
+	got := a.Snapshot()
+	want := map[string]Totals{
+		"claude-sonnet-4-5": {Requests: 2, CostUSD: 0.75, Usage: Usage{InputTokens: 11, OutputTokens: 5, CacheReadTokens: 100}},
+		"unknown":           {Requests: 1, Usage: Usage{OutputTokens: 1}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Snapshot = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,215 @@
+package vertex
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTokenURL is used when neither the config nor the key file names
+	// a token endpoint.
+	DefaultTokenURL = "https://oauth2.googleapis.com/token"
+	cloudScope      = "https://www.googleapis.com/auth/cloud-platform"
+
+	// refreshBefore renews cached tokens this long before they expire.
+	refreshBefore = time.Minute
+)
+
+type serviceAccountKey struct {
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// TokenSource mints access tokens with the JWT bearer grant and caches them
+// until shortly before they expire. It is safe for concurrent use: one caller
+// refreshes while the others wait for its result, and no lock is held during
+// the HTTP request.
+type TokenSource struct {
+	email    string
+	keyID    string
+	key      *rsa.PrivateKey
+	tokenURL string
+	client   *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+	refresh *tokenCall // in-flight refresh, if any
+}
+
+// tokenCall is one refresh shared by every caller that needs it.
+type tokenCall struct {
+	done    chan struct{}
+	token   string
+	expires time.Time
+	err     error
+}
+
+// NewTokenSource loads a service-account JSON key. tokenURL overrides the
+// key's token_uri, so tests can point it at a local server.
+func NewTokenSource(keyFile, tokenURL string, client *http.Client) (*TokenSource, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account key: %w", err)
+	}
+	var sa serviceAccountKey
+	if err := json.Unmarshal(data, &sa); err != nil {
+		return nil, fmt.Errorf("failed to parse service account key: %w", err)
+	}
+	if sa.ClientEmail == "" || sa.PrivateKey == "" {
+		return nil, errors.New("service account key is missing client_email or private_key")
+	}
+	key, err := parsePrivateKey(sa.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if tokenURL == "" {
+		tokenURL = sa.TokenURI
+	}
+	if tokenURL == "" {
+		tokenURL = DefaultTokenURL
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &TokenSource{
+		email:    sa.ClientEmail,
+		keyID:    sa.PrivateKeyID,
+		key:      key,
+		tokenURL: tokenURL,
+		client:   client,
+	}, nil
+}
+
+// Token returns a cached access token, minting a new one when needed. While
+// a refresh is in flight, a token that has not yet expired is still returned.
+func (ts *TokenSource) Token() (string, error) {
+	ts.mu.Lock()
+	now := time.Now()
+	if ts.token != "" && now.Before(ts.expires.Add(-refreshBefore)) {
+		defer ts.mu.Unlock()
+		return ts.token, nil
+	}
+	if call := ts.refresh; call != nil {
+		if ts.token != "" && now.Before(ts.expires) {
+			defer ts.mu.Unlock()
+			return ts.token, nil
+		}
+		ts.mu.Unlock()
+		<-call.done
+		return call.token, call.err
+	}
+	call := &tokenCall{done: make(chan struct{})}
+	ts.refresh = call
+	ts.mu.Unlock()
+
+	call.token, call.expires, call.err = ts.fetch()
+
+	ts.mu.Lock()
+	if call.err == nil {
+		ts.token, ts.expires = call.token, call.expires
+	}
+	ts.refresh = nil
+	ts.mu.Unlock()
+	close(call.done)
+	return call.token, call.err
+}
+
+// fetch requests a new token from the token endpoint.
+func (ts *TokenSource) fetch() (string, time.Time, error) {
+	assertion, err := ts.assertion(time.Now())
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	resp, err := ts.client.PostForm(ts.tokenURL, form)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to read token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("token endpoint returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tok); err != nil || tok.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("invalid token response: %s", strings.TrimSpace(string(body)))
+	}
+	if tok.ExpiresIn <= 0 {
+		tok.ExpiresIn = 3600
+	}
+	return tok.AccessToken, time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second), nil
+}
+
+// assertion builds the signed RS256 JWT sent to the token endpoint.
+func (ts *TokenSource) assertion(now time.Time) (string, error) {
+	header := map[string]string{"alg": "RS256", "typ": "JWT"}
+	if ts.keyID != "" {
+		header["kid"] = ts.keyID
+	}
+	claims := map[string]interface{}{
+		"iss":   ts.email,
+		"scope": cloudScope,
+		"aud":   ts.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+	headerJSON, _ := json.Marshal(header)
+	claimsJSON, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." +
+		base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, ts.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token request: %w", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func parsePrivateKey(data string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("service account private_key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse service account private_key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("service account private_key is not an RSA key")
+	}
+	return key, nil
+}
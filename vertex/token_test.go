@@ -0,0 +1,229 @@
+package vertex
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tokenServer is a stand-in OAuth token endpoint that checks the JWT bearer
+// assertion and hands out numbered tokens.
+type tokenServer struct {
+	*httptest.Server
+	key       *rsa.PublicKey
+	expiresIn int
+	hits      atomic.Int32
+	gate      chan struct{} // when set, each request waits for a send
+	entered   chan struct{} // when set, signalled as each request arrives
+}
+
+func newTokenServer(t *testing.T, key *rsa.PublicKey, expiresIn int) *tokenServer {
+	ts := &tokenServer{key: key, expiresIn: expiresIn}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := ts.hits.Add(1)
+		if ts.entered != nil {
+			ts.entered <- struct{}{}
+		}
+		if ts.gate != nil {
+			<-ts.gate
+		}
+		if err := ts.check(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":%d,"token_type":"Bearer"}`, n, ts.expiresIn)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func (ts *tokenServer) check(r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	if got := r.PostForm.Get("grant_type"); got != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+		return fmt.Errorf("grant_type = %q", got)
+	}
+	parts := strings.Split(r.PostForm.Get("assertion"), ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("assertion is not a JWT")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(ts.key, crypto.SHA256, digest[:], sig); err != nil {
+		return fmt.Errorf("bad signature: %v", err)
+	}
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+	var claims struct {
+		Iss   string `json:"iss"`
+		Aud   string `json:"aud"`
+		Scope string `json:"scope"`
+	}
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		return err
+	}
+	if claims.Iss != "proxy@example.iam.gserviceaccount.com" || claims.Aud != ts.URL+"/token" || claims.Scope != cloudScope {
+		return fmt.Errorf("unexpected claims %s", claimsJSON)
+	}
+	return nil
+}
+
+// writeKey writes a service-account key file whose token_uri points nowhere,
+// so the tests prove the configured token URL wins.
+func writeKey(t *testing.T) (string, *rsa.PublicKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "proxy@example.iam.gserviceaccount.com",
+		"private_key_id": "key-1",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":      "http://127.0.0.1:1/unused",
+	})
+	path := filepath.Join(t.TempDir(), "sa.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, &key.PublicKey
+}
+
+func TestTokenMintsAndCaches(t *testing.T) {
+	keyFile, pub := writeKey(t)
+	server := newTokenServer(t, pub, 3600)
+	ts, err := NewTokenSource(keyFile, server.URL+"/token", server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		token, err := ts.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "token-1" {
+			t.Fatalf("Token() = %q, want token-1", token)
+		}
+	}
+	if hits := server.hits.Load(); hits != 1 {
+		t.Errorf("token endpoint called %d times, want 1", hits)
+	}
+}
+
+func TestTokenConcurrentCallersShareOneRefresh(t *testing.T) {
+	keyFile, pub := writeKey(t)
+	server := newTokenServer(t, pub, 3600)
+	server.gate = make(chan struct{})
+	ts, err := NewTokenSource(keyFile, server.URL+"/token", server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 20)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], _ = ts.Token()
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(server.gate)
+	wg.Wait()
+
+	for i, token := range tokens {
+		if token != "token-1" {
+			t.Errorf("caller %d got %q, want token-1", i, token)
+		}
+	}
+	if hits := server.hits.Load(); hits != 1 {
+		t.Errorf("token endpoint called %d times, want 1", hits)
+	}
+}
+
+func TestTokenRefreshDoesNotBlockValidToken(t *testing.T) {
+	keyFile, pub := writeKey(t)
+	// Tokens live 30s, inside the refresh window, so every call after the
+	// first wants a refresh while the current token is still usable.
+	server := newTokenServer(t, pub, 30)
+	ts, err := NewTokenSource(keyFile, server.URL+"/token", server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, err := ts.Token(); err != nil || token != "token-1" {
+		t.Fatalf("Token() = %q, %v", token, err)
+	}
+
+	server.gate = make(chan struct{})
+	server.entered = make(chan struct{}, 1)
+	refreshed := make(chan string)
+	go func() {
+		token, _ := ts.Token()
+		refreshed <- token
+	}()
+	<-server.entered // the refresh is now blocked in the token endpoint
+
+	done := make(chan string)
+	go func() {
+		token, _ := ts.Token()
+		done <- token
+	}()
+	select {
+	case token := <-done:
+		if token != "token-1" {
+			t.Errorf("Token() during refresh = %q, want the cached token-1", token)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Token() blocked behind an in-flight refresh")
+	}
+
+	close(server.gate)
+	if token := <-refreshed; token != "token-2" {
+		t.Errorf("refresh returned %q, want token-2", token)
+	}
+}
+
+func TestTokenEndpointError(t *testing.T) {
+	keyFile, _ := writeKey(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+	}))
+	defer server.Close()
+	ts, err := NewTokenSource(keyFile, server.URL, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := ts.Token(); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+			t.Fatalf("Token() error = %v, want the endpoint's error", err)
+		}
+	}
+}
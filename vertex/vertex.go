@@ -0,0 +1,76 @@
+// Package vertex adapts Anthropic Messages requests to Google Vertex AI and
+// mints OAuth access tokens from a service-account key.
+package vertex
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/schachte/claudecode-opencode-proxy/anthropic"
+)
+
+// AnthropicVersion is the body-level API version Vertex expects.
+const AnthropicVersion = "vertex-2023-10-16"
+
+// Endpoint returns the regional Vertex AI base URL.
+func Endpoint(region string) string {
+	if region == "" || region == "global" {
+		return "https://aiplatform.googleapis.com"
+	}
+	return fmt.Sprintf("https://%s-aiplatform.googleapis.com", region)
+}
+
+// PredictPath returns the publisher model path for a request. Streaming
+// requests use streamRawPredict, the rest rawPredict.
+func PredictPath(project, region, model string, streaming bool) string {
+	if region == "" {
+		region = "global"
+	}
+	method := "rawPredict"
+	if streaming {
+		method = "streamRawPredict"
+	}
+	return fmt.Sprintf("/v1/projects/%s/locations/%s/publishers/anthropic/models/%s:%s",
+		url.PathEscape(project), url.PathEscape(region), url.PathEscape(model), method)
+}
+
+// TranslateRequest turns an Anthropic Messages body into a Vertex body: the
+// model moves into the URL and anthropic_version is added.
+func TranslateRequest(req map[string]interface{}) ([]byte, error) {
+	out := make(map[string]interface{}, len(req)+1)
+	for key, value := range req {
+		if key != "model" {
+			out[key] = value
+		}
+	}
+	out["anthropic_version"] = AnthropicVersion
+	return json.Marshal(out)
+}
+
+// TranslateError converts a Google API error body ({"error": {"message": ...}})
+// into the Anthropic error schema. Errors already in that schema, which Vertex
+// returns for model-level failures, pass through unchanged.
+func TranslateError(status int, data []byte) []byte {
+	var body struct {
+		Type  string `json:"type"`
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(data, &body) == nil {
+		if body.Type == "error" {
+			return data
+		}
+		if body.Error.Message != "" {
+			return anthropic.ErrorBody(anthropic.ErrorType(status), body.Error.Message)
+		}
+	}
+	message := strings.TrimSpace(string(data))
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	return anthropic.ErrorBody(anthropic.ErrorType(status), message)
+}